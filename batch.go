@@ -0,0 +1,202 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// BatchDocumentBuilder is a DocumentBuilder that can also build many messages
+// at once, so it can batch its I/O. The processor detects it and calls
+// BuildBatch with all messages of the same MessageType and Namespace routed to
+// the same builder, in chunks of up to the size given to
+// WithBuildBatchSizeOption.
+//
+// The StreamProcessor calls Build for each Message instead, and so does the
+// processor when the builder is wrapped by a BuilderMiddleware that does not
+// implement BatchDocumentBuilder, or when a Router returns a builder that is
+// not comparable.
+type BatchDocumentBuilder interface {
+	DocumentBuilder
+
+	// BuildBatch transforms []Message into one BatchBuildResult for each
+	// Message, in the same order. If it returns an error, every Message of
+	// the chunk fails with it and the chunk is retried as a whole, following
+	// the RetryPolicy of its MessageType. The build timeout of its
+	// MessageType also applies to the whole call.
+	BuildBatch(context.Context, []Message) ([]BatchBuildResult, error)
+}
+
+// BatchBuildResult is the outcome of building a single Message in a
+// BatchDocumentBuilder's BuildBatch. It follows the same semantics of Build:
+// no documents means the Message is ignored, and Err may be returned by
+// IgnoreMessage.
+type BatchBuildResult struct {
+	Documents []Document
+	// Children are child messages, like the ones returned by an
+	// ExpandingDocumentBuilder.
+	Children []Message
+	Err      error
+}
+
+// buildUnit is the work of a single scheduler task: one Message, or a chunk of
+// messages for a BatchDocumentBuilder.
+type buildUnit struct {
+	builtMessages []*builtMessage
+	batchBuilder  BatchDocumentBuilder
+}
+
+// buildUnits splits the level into buildUnit, grouping the messages of each
+// BatchDocumentBuilder by MessageType, Namespace and routed builder. Units are
+// ordered by their first Message. A Message does not join a chunk if a later
+// unit has a Message of the same partition, so partitions keep their input
+// order.
+func (p *parallelProcessor) buildUnits(level []*builtMessage) []buildUnit {
+	type chunkKey struct {
+		messageType MessageType
+		namespace   Namespace
+		// routed is the builder returned by a Router, as messages of the same
+		// MessageType may be routed to different builders.
+		routed DocumentBuilder
+	}
+
+	var units []buildUnit
+	chunks := make(map[chunkKey]int)
+	// lastUnits holds the last unit of each partition key.
+	lastUnits := make(map[string]int)
+	for _, builtMsg := range level {
+		partitionKeys := p.partitionKeys(builtMsg.message)
+
+		builder, routed, _ := p.lookupBuilder(builtMsg.message)
+		batchBuilder, ok := builder.(BatchDocumentBuilder)
+		if !ok || (routed != nil && !reflect.ValueOf(routed).Comparable()) {
+			for _, partitionKey := range partitionKeys {
+				lastUnits[partitionKey] = len(units)
+			}
+			units = append(units, buildUnit{builtMessages: []*builtMessage{builtMsg}})
+			continue
+		}
+
+		key := chunkKey{
+			messageType: builtMsg.message.GetType(),
+			namespace:   builtMsg.message.GetNamespace(),
+			routed:      routed,
+		}
+		i, ok := chunks[key]
+		if !ok ||
+			(p.buildBatchSize > 0 && len(units[i].builtMessages) >= p.buildBatchSize) ||
+			!isLastUnitOfPartitions(lastUnits, partitionKeys, i) {
+			i = len(units)
+			chunks[key] = i
+			units = append(units, buildUnit{batchBuilder: batchBuilder})
+		}
+		units[i].builtMessages = append(units[i].builtMessages, builtMsg)
+		for _, partitionKey := range partitionKeys {
+			lastUnits[partitionKey] = i
+		}
+	}
+	return units
+}
+
+// isLastUnitOfPartitions returns true if no unit after the i-th one has a
+// Message of the given partitions.
+func isLastUnitOfPartitions(lastUnits map[string]int, partitionKeys []string, i int) bool {
+	for _, partitionKey := range partitionKeys {
+		if last, ok := lastUnits[partitionKey]; ok && last > i {
+			return false
+		}
+	}
+	return true
+}
+
+// buildBatch builds the given builtMessages with a single BuildBatch call,
+// filling each of them with its outcome. It returns the first failure.
+func (p *parallelProcessor) buildBatch(
+	ctx context.Context,
+	builder BatchDocumentBuilder,
+	builtMsgs []*builtMessage,
+) error {
+	var firstErr error
+	keepFirst := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	pending := make([]*builtMessage, 0, len(builtMsgs))
+	msgs := make([]Message, 0, len(builtMsgs))
+	for _, builtMsg := range builtMsgs {
+		err := p.checkExpansion(builtMsg)
+		if err != nil {
+			builtMsg.message.UpdateLogWithData(ctx)
+			keepFirst(p.failMessage(ctx, builtMsg, err))
+			continue
+		}
+		pending = append(pending, builtMsg)
+		msgs = append(msgs, builtMsg.message)
+	}
+	if len(pending) == 0 {
+		return firstErr
+	}
+
+	results, batchErr := p.buildDocumentsBatch(ctx, builder, msgs)
+	for i, builtMsg := range pending {
+		var (
+			output buildOutput
+			err    = batchErr
+		)
+		if batchErr == nil {
+			output, err = newBuildOutput(results[i].Documents, results[i].Children, results[i].Err)
+		}
+
+		output, err = logBuildOutput(ctx, builtMsg.message, output, err)
+		keepFirst(p.finishMessage(ctx, builtMsg, output, err))
+	}
+	return firstErr
+}
+
+// buildDocumentsBatch calls the BatchDocumentBuilder with the same panic
+// recovery, span sampling, timeout and retries of a single Message build. The
+// timeout applies to the whole BuildBatch call.
+func (p *parallelProcessor) buildDocumentsBatch(
+	ctx context.Context,
+	builder BatchDocumentBuilder,
+	msgs []Message,
+) ([]BatchBuildResult, error) {
+	messageType := msgs[0].GetType()
+
+	build := recoverBuild(func(ctx context.Context) ([]BatchBuildResult, error) {
+		defer func(start time.Time) {
+			p.metrics.ObserveBuildDuration(ctx, messageType, time.Since(start))
+		}(time.Now())
+
+		results, err := builder.BuildBatch(ctx, msgs)
+		if err != nil {
+			return nil, err
+		}
+		return results, checkBatchResults(msgs, results)
+	})
+	if p.spans.sampleBuild() {
+		build = traceBuildBatch(p.spans.tracer(), msgs, build)
+	}
+
+	timeout := p.buildTimeoutFor(messageType)
+	return retryBuild(ctx, p.retryPolicyFor(messageType), func(ctx context.Context) ([]BatchBuildResult, error) {
+		return withBuildTimeout(ctx, timeout, build)
+	})
+}
+
+// checkBatchResults returns ErrBatchResultsMismatch if there is not one
+// BatchBuildResult for each Message.
+func checkBatchResults(msgs []Message, results []BatchBuildResult) error {
+	if len(results) != len(msgs) {
+		return errors.E(
+			ErrBatchResultsMismatch,
+			errors.KV("messages", len(msgs)),
+			errors.KV("results", len(results)),
+		)
+	}
+	return nil
+}
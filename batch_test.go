@@ -0,0 +1,174 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Process_BatchDocumentBuilder(t *testing.T) {
+	t.Parallel()
+
+	msgs := []Message{
+		&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "ignore", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "3", namespace: "tiramisu", messageType: "type-2"},
+		&mockMessage{id: "fail", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "5", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "6", namespace: "tiramisu", messageType: "type-1"},
+	}
+
+	tests := []struct {
+		name string
+
+		batchErr  error
+		batchSize int
+
+		expectedBatchSizes []int
+		expectedDocuments  []Document
+		expectedIgnored    []int
+		expectedFailures   []int
+	}{
+		{
+			name:               "single batch",
+			expectedBatchSizes: []int{5},
+			expectedDocuments: []Document{
+				mockDocument{id: "1"},
+				mockDocument{id: "3"},
+				mockDocument{id: "5"},
+				mockDocument{id: "6"},
+			},
+			expectedIgnored:  []int{1},
+			expectedFailures: []int{3},
+		},
+		{
+			name:               "chunked",
+			batchSize:          2,
+			expectedBatchSizes: []int{1, 2, 2},
+			expectedDocuments: []Document{
+				mockDocument{id: "1"},
+				mockDocument{id: "3"},
+				mockDocument{id: "5"},
+				mockDocument{id: "6"},
+			},
+			expectedIgnored:  []int{1},
+			expectedFailures: []int{3},
+		},
+		{
+			name:               "batch failure",
+			batchErr:           errors.New("database is down"),
+			batchSize:          4,
+			expectedBatchSizes: []int{1, 4},
+			expectedDocuments:  []Document{mockDocument{id: "3"}},
+			expectedFailures:   []int{0, 1, 3, 4, 5},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			builder := &batchingDocumentBuilder{err: test.batchErr}
+			parallelProcessor := NewParallelProcessor(
+				map[MessageType]DocumentBuilder{
+					"type-1": builder,
+					"type-2": newConcurrencyTrackingBuilder(),
+				},
+				WithBuildBatchSizeOption(test.batchSize),
+			)
+
+			result, err := parallelProcessor.Process(context.Background(), msgs)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedDocuments, result.Documents)
+
+			var ignored []int
+			for _, ignoredMsg := range result.Ignored {
+				ignored = append(ignored, ignoredMsg.Index)
+			}
+			assert.Equal(t, test.expectedIgnored, ignored)
+
+			var failures []int
+			for _, failure := range result.Failures {
+				failures = append(failures, failure.Index)
+			}
+			assert.Equal(t, test.expectedFailures, failures)
+
+			slices.Sort(builder.batchSizes)
+			assert.Equal(t, test.expectedBatchSizes, builder.batchSizes)
+		})
+	}
+}
+
+func Test_Process_RoutedBatchDocumentBuilders(t *testing.T) {
+	t.Parallel()
+
+	odd := &batchingDocumentBuilder{}
+	even := &batchingDocumentBuilder{}
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{},
+		WithRouterOption(RouterFunc(func(msg Message) (DocumentBuilder, bool) {
+			id, _ := strconv.Atoi(msg.(*mockMessage).id)
+			if id%2 == 0 {
+				return even, true
+			}
+			return odd, true
+		})),
+	)
+
+	result, err := parallelProcessor.Process(context.Background(), []Message{
+		&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "2", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "3", namespace: "tiramisu", messageType: "type-1"},
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(
+		t,
+		[]Document{mockDocument{id: "1"}, mockDocument{id: "2"}, mockDocument{id: "3"}},
+		result.Documents,
+	)
+	assert.Equal(t, []int{2}, odd.batchSizes)
+	assert.Equal(t, []int{1}, even.batchSizes)
+}
+
+type batchingDocumentBuilder struct {
+	err error
+
+	mu         sync.Mutex
+	batchSizes []int
+}
+
+func (b *batchingDocumentBuilder) Build(ctx context.Context, msg Message) ([]Document, error) {
+	results, err := b.BuildBatch(ctx, []Message{msg})
+	if err != nil {
+		return nil, err
+	}
+	return results[0].Documents, results[0].Err
+}
+
+func (b *batchingDocumentBuilder) BuildBatch(_ context.Context, msgs []Message) ([]BatchBuildResult, error) {
+	b.mu.Lock()
+	b.batchSizes = append(b.batchSizes, len(msgs))
+	b.mu.Unlock()
+
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	results := make([]BatchBuildResult, len(msgs))
+	for i, msg := range msgs {
+		switch id := msg.(*mockMessage).id; id {
+		case "ignore":
+			results[i].Err = IgnoreMessage("not needed")
+		case "fail":
+			results[i].Err = errors.New("bad message")
+		default:
+			results[i].Documents = []Document{mockDocument{id: id}}
+		}
+	}
+	return results, nil
+}
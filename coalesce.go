@@ -0,0 +1,84 @@
+package gomsgprocessor
+
+import "reflect"
+
+// MessageReduceFunc combines two messages with the same key into the one that
+// is built. kept is the result of the previous calls and next is the following
+// Message, in input order.
+type MessageReduceFunc func(kept, next Message) Message
+
+// KeepFirstMessage is a MessageReduceFunc that builds only the first Message of
+// each key.
+func KeepFirstMessage(kept, _ Message) Message {
+	return kept
+}
+
+// KeepLatestMessage is a MessageReduceFunc that builds only the last Message
+// of each key.
+func KeepLatestMessage(_, next Message) Message {
+	return next
+}
+
+// messageCoalescer is the configuration of WithCoalesceMessagesOption.
+type messageCoalescer struct {
+	key    MessageKeyFunc
+	reduce MessageReduceFunc
+}
+
+// messageCoalescerFor returns the messageCoalescer registered for the
+// Namespace, falling back to the processor's default one.
+func (p *parallelProcessor) messageCoalescerFor(namespace Namespace) (messageCoalescer, bool) {
+	if coalescer, ok := p.messageCoalescerByNamespace[namespace]; ok {
+		return coalescer, true
+	}
+	return p.messageCoalescer, p.messageCoalescer.key != nil
+}
+
+// newBuiltMessages returns one builtMessage, still to be built, for each
+// Message, after coalescing the messages with the same Namespace and key. The
+// coalesced Message takes the place of the first Message of its key and the
+// index of the Message kept by the MessageReduceFunc, or of the last one
+// merged if it returns a new Message.
+func (p *parallelProcessor) newBuiltMessages(msgs []Message) []builtMessage {
+	type coalesceKey struct {
+		namespace Namespace
+		key       string
+	}
+
+	builtMessages := make([]builtMessage, 0, len(msgs))
+	positions := make(map[coalesceKey]int)
+	for i, msg := range msgs {
+		coalescer, ok := p.messageCoalescerFor(msg.GetNamespace())
+		if !ok {
+			builtMessages = append(builtMessages, builtMessage{message: msg, index: i})
+			continue
+		}
+
+		key := coalesceKey{namespace: msg.GetNamespace(), key: coalescer.key(msg)}
+		position, ok := positions[key]
+		if key.key == "" || !ok {
+			positions[key] = len(builtMessages)
+			builtMessages = append(builtMessages, builtMessage{message: msg, index: i})
+			continue
+		}
+
+		builtMsg := &builtMessages[position]
+		reduced := coalescer.reduce(builtMsg.message, msg)
+		if !sameMessage(reduced, builtMsg.message) {
+			builtMsg.index = i
+		}
+		builtMsg.message = reduced
+		builtMsg.coalesced++
+	}
+	return builtMessages
+}
+
+// sameMessage reports whether a and b are the same Message. Messages that are
+// not comparable are never the same.
+func sameMessage(a, b Message) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if !va.IsValid() || !vb.IsValid() || va.Type() != vb.Type() {
+		return false
+	}
+	return va.Comparable() && vb.Comparable() && a == b
+}
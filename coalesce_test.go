@@ -0,0 +1,193 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Process_CoalesceMessages(t *testing.T) {
+	t.Parallel()
+
+	msgs := []Message{
+		&mockMessage{id: "a-1", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "b-1", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "a-2", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "a-1", namespace: "potato", messageType: "type-1"},
+		&mockMessage{id: "a-3", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "a-2", namespace: "potato", messageType: "type-1"},
+	}
+	entityKey := func(msg Message) string {
+		return msg.(*mockMessage).id[:1]
+	}
+
+	tests := []struct {
+		name string
+
+		opts []Option
+
+		expectedBuilds    int32
+		expectedDocuments []Document
+		expectedCoalesced map[Namespace]int
+	}{
+		{
+			name:           "no coalescing",
+			expectedBuilds: 6,
+			expectedDocuments: []Document{
+				mockDocument{id: "a-1"},
+				mockDocument{id: "b-1"},
+				mockDocument{id: "a-2"},
+				mockDocument{id: "a-3"},
+				mockDocument{id: "a-1"},
+				mockDocument{id: "a-2"},
+			},
+		},
+		{
+			name:           "keep latest",
+			opts:           []Option{WithCoalesceMessagesOption(entityKey, KeepLatestMessage)},
+			expectedBuilds: 3,
+			expectedDocuments: []Document{
+				mockDocument{id: "a-3"},
+				mockDocument{id: "b-1"},
+				mockDocument{id: "a-2"},
+			},
+			expectedCoalesced: map[Namespace]int{"tiramisu": 2, "potato": 1},
+		},
+		{
+			name:           "keep first by namespace",
+			opts:           []Option{WithCoalesceMessagesOption(entityKey, KeepFirstMessage, "potato")},
+			expectedBuilds: 5,
+			expectedDocuments: []Document{
+				mockDocument{id: "a-1"},
+				mockDocument{id: "b-1"},
+				mockDocument{id: "a-2"},
+				mockDocument{id: "a-3"},
+				mockDocument{id: "a-1"},
+			},
+			expectedCoalesced: map[Namespace]int{"potato": 1},
+		},
+		{
+			name: "custom reducer",
+			opts: []Option{
+				WithCoalesceMessagesOption(entityKey, func(kept, next Message) Message {
+					return &mockMessage{
+						id:          kept.(*mockMessage).id + "+" + next.(*mockMessage).id,
+						namespace:   kept.GetNamespace(),
+						messageType: kept.GetType(),
+					}
+				}, "tiramisu"),
+			},
+			expectedBuilds: 4,
+			expectedDocuments: []Document{
+				mockDocument{id: "a-1+a-2+a-3"},
+				mockDocument{id: "b-1"},
+				mockDocument{id: "a-1"},
+				mockDocument{id: "a-2"},
+			},
+			expectedCoalesced: map[Namespace]int{"tiramisu": 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var builds atomic.Int32
+			builder := DocumentBuilderFunc(func(_ context.Context, msg Message) ([]Document, error) {
+				builds.Add(1)
+				return []Document{mockDocument{id: msg.(*mockMessage).id}}, nil
+			})
+			parallelProcessor := NewParallelProcessor(
+				map[MessageType]DocumentBuilder{"type-1": builder},
+				test.opts...,
+			)
+
+			result, err := parallelProcessor.Process(context.Background(), msgs)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedBuilds, builds.Load())
+			assert.Equal(t, test.expectedDocuments, result.Documents)
+			assert.Equal(t, test.expectedCoalesced, result.Coalesced)
+		})
+	}
+}
+
+func Test_Process_CoalesceMessages_Failure(t *testing.T) {
+	t.Parallel()
+
+	msgs := []Message{
+		&mockMessage{id: "a-1", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "b-1", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "a-2", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "a-3", namespace: "tiramisu", messageType: "type-1"},
+	}
+	entityKey := func(msg Message) string {
+		return msg.(*mockMessage).id[:1]
+	}
+
+	tests := []struct {
+		name string
+
+		reduce MessageReduceFunc
+
+		expectedIndex   int
+		expectedMessage Message
+	}{
+		{
+			name:            "keep first",
+			reduce:          KeepFirstMessage,
+			expectedIndex:   0,
+			expectedMessage: msgs[0],
+		},
+		{
+			name:            "keep latest",
+			reduce:          KeepLatestMessage,
+			expectedIndex:   3,
+			expectedMessage: msgs[3],
+		},
+		{
+			name: "custom reducer",
+			reduce: func(kept, next Message) Message {
+				return &mockMessage{
+					id:          kept.(*mockMessage).id + "+" + next.(*mockMessage).id,
+					namespace:   kept.GetNamespace(),
+					messageType: kept.GetType(),
+				}
+			},
+			expectedIndex: 3,
+			expectedMessage: &mockMessage{
+				id:          "a-1+a-2+a-3",
+				namespace:   "tiramisu",
+				messageType: "type-1",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			builder := DocumentBuilderFunc(func(_ context.Context, msg Message) ([]Document, error) {
+				id := msg.(*mockMessage).id
+				if strings.HasPrefix(id, "a") {
+					return nil, errors.New("bad message")
+				}
+				return []Document{mockDocument{id: id}}, nil
+			})
+			parallelProcessor := NewParallelProcessor(
+				map[MessageType]DocumentBuilder{"type-1": builder},
+				WithCoalesceMessagesOption(entityKey, test.reduce),
+			)
+
+			result, err := parallelProcessor.Process(context.Background(), msgs)
+			assert.NoError(t, err)
+			if assert.Len(t, result.Failures, 1) {
+				assert.Equal(t, test.expectedIndex, result.Failures[0].Index)
+				assert.Equal(t, test.expectedMessage, result.Failures[0].Message)
+			}
+		})
+	}
+}
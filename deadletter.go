@@ -0,0 +1,211 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// DeadLetter is a Message that failed to build, sent to a DeadLetterSink.
+type DeadLetter struct {
+	Message  Message
+	Err      error
+	Metadata DeadLetterMetadata
+}
+
+// DeadLetterMetadata describes the failure of a DeadLetter.
+type DeadLetterMetadata struct {
+	// Code is the (foundationkit/errors).Code of the error. When the error has
+	// no code, ErrCodeBuildDocuments is used.
+	Code errors.Code
+	// Index is the position of the input Message in the given []Message, or of
+	// the one the Message descends from. It is always zero in a
+	// StreamProcessor.
+	Index int
+	// Depth is zero for input messages and grows for each expansion of an
+	// ExpandingDocumentBuilder.
+	Depth int
+	// FailedAt is when the Message failed.
+	FailedAt time.Time
+}
+
+// DeadLetterSink receives the messages that failed to build, including the
+// ones whose MessageType has no DocumentBuilder. Implementations must be safe
+// for concurrent use.
+//
+// See WithDeadLetterSinkOption.
+type DeadLetterSink interface {
+	// Send stores the DeadLetter. If it fails, the Message fails as if there
+	// were no DeadLetterSink.
+	Send(context.Context, DeadLetter) error
+}
+
+// deadLetter sends the failed builtMessage to the DeadLetterSink. It returns
+// nil if the failure was handled by the sink, or the error the Message must
+// fail with otherwise.
+func (p *parallelProcessor) deadLetter(ctx context.Context, builtMsg *builtMessage, err error) error {
+	code := errors.GetCode(err)
+	if code == "" {
+		code = ErrCodeBuildDocuments
+	}
+
+	sink := p.deadLetterSink
+	if code == ErrCodeMsgTypeHasNoBuilder {
+		fallback := p.unknownMessageTypeFallbackFor(builtMsg.message.GetNamespace())
+		if fallback.deadLetterSink != nil {
+			sink = fallback.deadLetterSink
+		}
+	}
+
+	// Failures caused by the batch being canceled are not the Message's fault.
+	if sink == nil || ctx.Err() != nil {
+		return err
+	}
+
+	sinkErr := sink.Send(ctx, DeadLetter{
+		Message: builtMsg.message,
+		Err:     err,
+		Metadata: DeadLetterMetadata{
+			Code:     code,
+			Index:    builtMsg.index,
+			Depth:    builtMsg.depth,
+			FailedAt: time.Now(),
+		},
+	})
+	if sinkErr != nil {
+		return errors.E(sinkErr, ErrCodeDeadLetter, errors.KV("build_error", err.Error()))
+	}
+
+	log.Ctx(ctx).Warn().Err(err).Msg("Message sent to dead letter sink...")
+	return nil
+}
+
+// InMemoryDeadLetterSink is a DeadLetterSink that keeps the dead letters in
+// memory, useful for tests and for replaying failures in the same process.
+type InMemoryDeadLetterSink struct {
+	mu          sync.Mutex
+	deadLetters []DeadLetter
+}
+
+// NewInMemoryDeadLetterSink returns an empty InMemoryDeadLetterSink.
+func NewInMemoryDeadLetterSink() *InMemoryDeadLetterSink {
+	return &InMemoryDeadLetterSink{}
+}
+
+// Send appends the DeadLetter to the sink.
+func (s *InMemoryDeadLetterSink) Send(_ context.Context, deadLetter DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deadLetters = append(s.deadLetters, deadLetter)
+	return nil
+}
+
+// DeadLetters returns a copy of the dead letters received so far, in the
+// order they were sent.
+func (s *InMemoryDeadLetterSink) DeadLetters() []DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.deadLetters)
+}
+
+// Drain returns the dead letters received so far and removes them from the
+// sink.
+func (s *InMemoryDeadLetterSink) Drain() []DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deadLetters := s.deadLetters
+	s.deadLetters = nil
+	return deadLetters
+}
+
+// JSONLDeadLetterSink is a DeadLetterSink that writes each DeadLetter as a
+// JSON line. The Message is encoded with encoding/json, so it must implement
+// json.Marshaler if its fields are not exported.
+type JSONLDeadLetterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// jsonlDeadLetter is the JSON line written by JSONLDeadLetterSink.
+type jsonlDeadLetter struct {
+	MessageType MessageType     `json:"messageType"`
+	Namespace   Namespace       `json:"namespace"`
+	Message     json.RawMessage `json:"message"`
+	Error       string          `json:"error"`
+	Code        errors.Code     `json:"code"`
+	Index       int             `json:"index"`
+	Depth       int             `json:"depth"`
+	FailedAt    time.Time       `json:"failedAt"`
+}
+
+// NewJSONLDeadLetterSink returns a JSONLDeadLetterSink that writes to w.
+func NewJSONLDeadLetterSink(w io.Writer) *JSONLDeadLetterSink {
+	return &JSONLDeadLetterSink{w: w}
+}
+
+// NewJSONLFileDeadLetterSink returns a JSONLDeadLetterSink that appends to the
+// file at path, creating it if needed. Close must be called to close the file.
+func NewJSONLFileDeadLetterSink(path string) (*JSONLDeadLetterSink, error) {
+	const op = errors.Op("gomsgprocessor.NewJSONLFileDeadLetterSink")
+
+	//nolint:gosec // the path is chosen by the caller on purpose.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return NewJSONLDeadLetterSink(f), nil
+}
+
+// Send writes the DeadLetter as a single JSON line.
+func (s *JSONLDeadLetterSink) Send(_ context.Context, deadLetter DeadLetter) error {
+	const op = errors.Op("gomsgprocessor.JSONLDeadLetterSink.Send")
+
+	msg, err := json.Marshal(deadLetter.Message)
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	line, err := json.Marshal(jsonlDeadLetter{
+		MessageType: deadLetter.Message.GetType(),
+		Namespace:   deadLetter.Message.GetNamespace(),
+		Message:     msg,
+		Error:       deadLetter.Err.Error(),
+		Code:        deadLetter.Metadata.Code,
+		Index:       deadLetter.Metadata.Index,
+		Depth:       deadLetter.Metadata.Depth,
+		FailedAt:    deadLetter.Metadata.FailedAt,
+	})
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(append(line, '\n'))
+	if err != nil {
+		return errors.E(op, err)
+	}
+	return nil
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (s *JSONLDeadLetterSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if closer, ok := s.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
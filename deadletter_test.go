@@ -0,0 +1,130 @@
+package gomsgprocessor
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MakeDocuments_DeadLetterSink(t *testing.T) {
+	t.Parallel()
+
+	sink := NewInMemoryDeadLetterSink()
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": newConcurrencyTrackingBuilder(),
+			"type-2": panickingDocumentBuilder{},
+		},
+		WithDeadLetterSinkOption(sink),
+	)
+
+	msgs := []Message{
+		&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "2", namespace: "tiramisu", messageType: "type-2"},
+		&mockMessage{id: "3", namespace: "tiramisu", messageType: "type-3"},
+	}
+	documents, err := parallelProcessor.MakeDocuments(context.Background(), msgs)
+	assert.NoError(t, err)
+	assert.Equal(t, []Document{mockDocument{id: "1"}}, documents)
+
+	deadLetters := sink.Drain()
+	sortDeadLetters(deadLetters)
+	if assert.Len(t, deadLetters, 2) {
+		assert.Equal(t, msgs[1], deadLetters[0].Message)
+		assert.Equal(t, ErrCodeBuildPanic, deadLetters[0].Metadata.Code)
+		assert.Equal(t, 1, deadLetters[0].Metadata.Index)
+		assert.Equal(t, msgs[2], deadLetters[1].Message)
+		assert.ErrorIs(t, deadLetters[1].Err, ErrMsgTypeHasNoBuilder)
+		assert.Equal(t, ErrCodeMsgTypeHasNoBuilder, deadLetters[1].Metadata.Code)
+		assert.Equal(t, 2, deadLetters[1].Metadata.Index)
+	}
+	assert.Empty(t, sink.DeadLetters())
+}
+
+func Test_Process_DeadLetterSinkFailure(t *testing.T) {
+	t.Parallel()
+
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{"type-1": newConcurrencyTrackingBuilder()},
+		WithDeadLetterSinkOption(failingDeadLetterSink{}),
+	)
+
+	msgs := []Message{
+		&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "2", namespace: "tiramisu", messageType: "type-2"},
+	}
+
+	result, err := parallelProcessor.Process(context.Background(), msgs)
+	assert.NoError(t, err)
+	assert.Equal(t, []Document{mockDocument{id: "1"}}, result.Documents)
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, 1, result.Failures[0].Index)
+		assert.Equal(t, ErrCodeDeadLetter, result.Failures[0].Code)
+	}
+
+	documents, err := parallelProcessor.MakeDocuments(context.Background(), msgs)
+	assert.Nil(t, documents)
+	assert.Equal(t, ErrCodeBuildDocuments, errors.GetCode(err))
+	assert.Contains(t, err.Error(), "sink is down")
+}
+
+func Test_JSONLDeadLetterSink(t *testing.T) {
+	t.Parallel()
+
+	failedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	deadLetter := DeadLetter{
+		Message: &mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+		Err:     errors.New("boom"),
+		Metadata: DeadLetterMetadata{
+			Code:     ErrCodeBuildDocuments,
+			Index:    3,
+			Depth:    1,
+			FailedAt: failedAt,
+		},
+	}
+
+	var buf bytes.Buffer
+	sink := NewJSONLDeadLetterSink(&buf)
+	require.NoError(t, sink.Send(context.Background(), deadLetter))
+	require.NoError(t, sink.Send(context.Background(), deadLetter))
+	require.NoError(t, sink.Close())
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+
+	assert.JSONEq(t, `{
+		"messageType": "type-1",
+		"namespace": "tiramisu",
+		"message": {},
+		"error": "boom",
+		"code": "FAILED_BUILD_DOCUMENTS",
+		"index": 3,
+		"depth": 1,
+		"failedAt": "2024-01-02T03:04:05Z"
+	}`, string(lines[0]))
+
+	fileSink, err := NewJSONLFileDeadLetterSink(filepath.Join(t.TempDir(), "dead-letters.jsonl"))
+	require.NoError(t, err)
+	assert.NoError(t, fileSink.Send(context.Background(), deadLetter))
+	assert.NoError(t, fileSink.Close())
+}
+
+type failingDeadLetterSink struct{}
+
+func (failingDeadLetterSink) Send(context.Context, DeadLetter) error {
+	return errors.New("sink is down")
+}
+
+func sortDeadLetters(deadLetters []DeadLetter) {
+	slices.SortFunc(deadLetters, func(a, b DeadLetter) int {
+		return cmp.Compare(a.Metadata.Index, b.Metadata.Index)
+	})
+}
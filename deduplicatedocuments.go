@@ -1,8 +1,174 @@
 package gomsgprocessor
 
+import "cmp"
+
 // DeduplicateDocumentsFunc is used to deduplicate a slice of Document.
 type DeduplicateDocumentsFunc func([]Document) ([]Document, error)
 
 func defaultDeduplicateDocumentsFunc(d []Document) ([]Document, error) {
 	return d, nil
 }
+
+// DeduplicateKeepFirst returns a DeduplicateDocumentsFunc that keeps only the
+// first Document of each key. The surviving documents keep their input order.
+func DeduplicateKeepFirst[K comparable](key func(Document) (K, error)) DeduplicateDocumentsFunc {
+	return DeduplicateDocumentsFunc(TypedDeduplicateKeepFirst(key))
+}
+
+// DeduplicateKeepLast returns a DeduplicateDocumentsFunc that keeps only the
+// last Document of each key. The surviving documents keep their input order.
+func DeduplicateKeepLast[K comparable](key func(Document) (K, error)) DeduplicateDocumentsFunc {
+	return DeduplicateDocumentsFunc(TypedDeduplicateKeepLast(key))
+}
+
+// DeduplicateKeepMax returns a DeduplicateDocumentsFunc that keeps only the
+// greatest Document of each key according to compare, which returns a
+// negative number when a < b, a positive number when a > b and zero when they
+// are equal. On ties, the last Document is kept. The surviving documents keep
+// their input order.
+func DeduplicateKeepMax[K comparable](
+	key func(Document) (K, error),
+	compare func(a, b Document) int,
+) DeduplicateDocumentsFunc {
+	return DeduplicateDocumentsFunc(TypedDeduplicateKeepMax(key, compare))
+}
+
+// DeduplicateKeepHighestVersion returns a DeduplicateDocumentsFunc that keeps
+// only the Document with the highest version of each key, like a timestamp or
+// a revision number. On ties, the last Document is kept. The surviving
+// documents keep their input order.
+func DeduplicateKeepHighestVersion[K comparable, V cmp.Ordered](
+	key func(Document) (K, error),
+	version func(Document) (V, error),
+) DeduplicateDocumentsFunc {
+	return DeduplicateDocumentsFunc(TypedDeduplicateKeepHighestVersion(key, version))
+}
+
+// DeduplicateMerge returns a DeduplicateDocumentsFunc that folds all documents
+// of the same key into one, calling merge with the result so far and the next
+// Document, in input order. The merged documents are placed where their key
+// was first seen.
+func DeduplicateMerge[K comparable](
+	key func(Document) (K, error),
+	merge func(merged, next Document) (Document, error),
+) DeduplicateDocumentsFunc {
+	return DeduplicateDocumentsFunc(TypedDeduplicateMerge(key, merge))
+}
+
+// TypedDeduplicateKeepFirst is the TypedDeduplicateDocumentsFunc version of
+// DeduplicateKeepFirst.
+func TypedDeduplicateKeepFirst[D any, K comparable](key func(D) (K, error)) TypedDeduplicateDocumentsFunc[D] {
+	return func(documents []D) ([]D, error) {
+		return keepByKey(documents, key, func(_, _ int) bool {
+			return false
+		})
+	}
+}
+
+// TypedDeduplicateKeepLast is the TypedDeduplicateDocumentsFunc version of
+// DeduplicateKeepLast.
+func TypedDeduplicateKeepLast[D any, K comparable](key func(D) (K, error)) TypedDeduplicateDocumentsFunc[D] {
+	return func(documents []D) ([]D, error) {
+		return keepByKey(documents, key, func(_, _ int) bool {
+			return true
+		})
+	}
+}
+
+// TypedDeduplicateKeepMax is the TypedDeduplicateDocumentsFunc version of
+// DeduplicateKeepMax.
+func TypedDeduplicateKeepMax[D any, K comparable](
+	key func(D) (K, error),
+	compare func(a, b D) int,
+) TypedDeduplicateDocumentsFunc[D] {
+	return func(documents []D) ([]D, error) {
+		return keepByKey(documents, key, func(kept, candidate int) bool {
+			return compare(documents[candidate], documents[kept]) >= 0
+		})
+	}
+}
+
+// TypedDeduplicateKeepHighestVersion is the TypedDeduplicateDocumentsFunc
+// version of DeduplicateKeepHighestVersion.
+func TypedDeduplicateKeepHighestVersion[D any, K comparable, V cmp.Ordered](
+	key func(D) (K, error),
+	version func(D) (V, error),
+) TypedDeduplicateDocumentsFunc[D] {
+	return func(documents []D) ([]D, error) {
+		versions := make([]V, len(documents))
+		for i, document := range documents {
+			v, err := version(document)
+			if err != nil {
+				return nil, err
+			}
+			versions[i] = v
+		}
+
+		return keepByKey(documents, key, func(kept, candidate int) bool {
+			return versions[candidate] >= versions[kept]
+		})
+	}
+}
+
+// TypedDeduplicateMerge is the TypedDeduplicateDocumentsFunc version of
+// DeduplicateMerge.
+func TypedDeduplicateMerge[D any, K comparable](
+	key func(D) (K, error),
+	merge func(merged, next D) (D, error),
+) TypedDeduplicateDocumentsFunc[D] {
+	return func(documents []D) ([]D, error) {
+		positions := make(map[K]int, len(documents))
+		mergedDocuments := make([]D, 0, len(documents))
+		for _, document := range documents {
+			k, err := key(document)
+			if err != nil {
+				return nil, err
+			}
+
+			i, ok := positions[k]
+			if !ok {
+				positions[k] = len(mergedDocuments)
+				mergedDocuments = append(mergedDocuments, document)
+				continue
+			}
+
+			merged, err := merge(mergedDocuments[i], document)
+			if err != nil {
+				return nil, err
+			}
+			mergedDocuments[i] = merged
+		}
+		return mergedDocuments, nil
+	}
+}
+
+// keepByKey keeps one document of each key. For every duplicate, replace
+// receives the index of the document kept so far and the index of the
+// duplicate, and returns true if the duplicate must be kept instead.
+func keepByKey[D any, K comparable](
+	documents []D,
+	key func(D) (K, error),
+	replace func(kept, candidate int) bool,
+) ([]D, error) {
+	keys := make([]K, len(documents))
+	kept := make(map[K]int, len(documents))
+	for i, document := range documents {
+		k, err := key(document)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = k
+
+		if j, ok := kept[k]; !ok || replace(j, i) {
+			kept[k] = i
+		}
+	}
+
+	deduplicatedDocuments := make([]D, 0, len(kept))
+	for i, document := range documents {
+		if kept[keys[i]] == i {
+			deduplicatedDocuments = append(deduplicatedDocuments, document)
+		}
+	}
+	return deduplicatedDocuments, nil
+}
@@ -0,0 +1,118 @@
+package gomsgprocessor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DeduplicateDocumentsFuncs(t *testing.T) {
+	t.Parallel()
+
+	key := func(d Document) (string, error) {
+		id, _, _ := strings.Cut(d.(mockDocument).id, "@")
+		return id, nil
+	}
+	version := func(d Document) (string, error) {
+		_, v, _ := strings.Cut(d.(mockDocument).id, "@")
+		return v, nil
+	}
+	documents := []Document{
+		mockDocument{id: "doc-1@2"},
+		mockDocument{id: "doc-2@1"},
+		mockDocument{id: "doc-1@3"},
+		mockDocument{id: "doc-3@1"},
+		mockDocument{id: "doc-1@1"},
+		mockDocument{id: "doc-2@1"},
+	}
+
+	tests := []struct {
+		name string
+
+		deduplicate DeduplicateDocumentsFunc
+
+		expectedResponse []Document
+		expectedError    string
+	}{
+		{
+			name:        "keep first",
+			deduplicate: DeduplicateKeepFirst(key),
+			expectedResponse: []Document{
+				mockDocument{id: "doc-1@2"},
+				mockDocument{id: "doc-2@1"},
+				mockDocument{id: "doc-3@1"},
+			},
+		},
+		{
+			name:        "keep last",
+			deduplicate: DeduplicateKeepLast(key),
+			expectedResponse: []Document{
+				mockDocument{id: "doc-3@1"},
+				mockDocument{id: "doc-1@1"},
+				mockDocument{id: "doc-2@1"},
+			},
+		},
+		{
+			name:        "keep highest version",
+			deduplicate: DeduplicateKeepHighestVersion(key, version),
+			expectedResponse: []Document{
+				mockDocument{id: "doc-1@3"},
+				mockDocument{id: "doc-3@1"},
+				mockDocument{id: "doc-2@1"},
+			},
+		},
+		{
+			name: "keep max",
+			deduplicate: DeduplicateKeepMax(key, func(a, b Document) int {
+				return -strings.Compare(a.(mockDocument).id, b.(mockDocument).id)
+			}),
+			expectedResponse: []Document{
+				mockDocument{id: "doc-3@1"},
+				mockDocument{id: "doc-1@1"},
+				mockDocument{id: "doc-2@1"},
+			},
+		},
+		{
+			name: "merge",
+			deduplicate: DeduplicateMerge(key, func(merged, next Document) (Document, error) {
+				return mockDocument{id: merged.(mockDocument).id + "+" + next.(mockDocument).id}, nil
+			}),
+			expectedResponse: []Document{
+				mockDocument{id: "doc-1@2+doc-1@3+doc-1@1"},
+				mockDocument{id: "doc-2@1+doc-2@1"},
+				mockDocument{id: "doc-3@1"},
+			},
+		},
+		{
+			name: "errors - merge",
+			deduplicate: DeduplicateMerge(key, func(Document, Document) (Document, error) {
+				return nil, errors.New("merge error")
+			}),
+			expectedError: "merge error",
+		},
+		{
+			name: "errors - key",
+			deduplicate: DeduplicateKeepFirst(func(Document) (string, error) {
+				return "", errors.New("key error")
+			}),
+			expectedError: "key error",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			deduplicatedDocuments, err := test.deduplicate(documents)
+
+			if test.expectedError == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedResponse, deduplicatedDocuments)
+			} else {
+				assert.EqualError(t, err, test.expectedError)
+			}
+		})
+	}
+}
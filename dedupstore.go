@@ -0,0 +1,280 @@
+package gomsgprocessor
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// DedupStore remembers the keys of the documents already emitted, so the same
+// Document is dropped when it is built again in a later batch. Implementations
+// must be safe for concurrent use.
+//
+// See WithDedupStoreOption, NewMemoryDedupStore and OpenFileDedupStore.
+type DedupStore interface {
+	// Contains returns, for each key, true if it was added within the
+	// store's time window.
+	Contains(ctx context.Context, keys []string) ([]bool, error)
+	// Add records the keys, restarting their time window.
+	Add(ctx context.Context, keys []string) error
+}
+
+// DocumentKeyFunc returns a key that identifies a Document in a DedupStore,
+// like its ID or a hash of its content. See DocumentContentHash.
+type DocumentKeyFunc func(Document) (string, error)
+
+// DocumentContentHash is a DocumentKeyFunc that returns the SHA-256 of the
+// Document encoded with encoding/json, so only exported fields are hashed. It
+// returns ErrEmptyDocumentContent for documents encoded without any field, as
+// they would all have the same key.
+func DocumentContentHash(document Document) (string, error) {
+	content, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	if bytes.Equal(content, []byte("{}")) || bytes.Equal(content, []byte("null")) {
+		return "", errors.E(ErrEmptyDocumentContent, errors.KV("type", fmt.Sprintf("%T", document)))
+	}
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// documentDedup is the configuration of WithDedupStoreOption.
+type documentDedup struct {
+	store DedupStore
+	key   DocumentKeyFunc
+}
+
+// documentDedupFor returns the documentDedup registered for the Namespace,
+// falling back to the processor's default one.
+func (p *parallelProcessor) documentDedupFor(namespace Namespace) (documentDedup, bool) {
+	if dedup, ok := p.documentDedupByNamespace[namespace]; ok {
+		return dedup, true
+	}
+	return p.documentDedup, p.documentDedup.store != nil
+}
+
+// emittedKeys are the keys of the documents about to be emitted. They are only
+// added to their DedupStore once the documents are written, so documents that
+// fail to be written are emitted again when their messages are redelivered.
+type emittedKeys struct {
+	store DedupStore
+	keys  []string
+}
+
+// addEmittedKeys adds the keys to their DedupStore.
+func addEmittedKeys(ctx context.Context, emitted ...emittedKeys) error {
+	for _, e := range emitted {
+		if e.store == nil || len(e.keys) == 0 {
+			continue
+		}
+		if err := e.store.Add(ctx, e.keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockDedupStores serializes the flushes of a StreamProcessor from the
+// DedupStore check until the keys are added, so concurrent streams of the
+// processor do not emit the same Document. It returns the function that
+// unlocks it, and does nothing if no DedupStore is configured.
+func (p *parallelProcessor) lockDedupStores() func() {
+	if p.documentDedup.store == nil && len(p.documentDedupByNamespace) == 0 {
+		return func() {}
+	}
+	p.dedupStoreMu.Lock()
+	return p.dedupStoreMu.Unlock
+}
+
+// dropEmittedDocuments drops the documents of the Namespace whose keys are in
+// the DedupStore and returns the keys of the remaining ones, which must be
+// added with addEmittedKeys once they are written.
+func (p *parallelProcessor) dropEmittedDocuments(
+	ctx context.Context,
+	namespace Namespace,
+	documents []Document,
+) ([]Document, emittedKeys, error) {
+	dedup, ok := p.documentDedupFor(namespace)
+	if !ok || len(documents) == 0 {
+		return documents, emittedKeys{}, nil
+	}
+
+	keys, err := dedupKeys(namespace, dedup.key, documents)
+	if err != nil {
+		return nil, emittedKeys{}, err
+	}
+
+	emitted, err := dedup.store.Contains(ctx, keys)
+	if err != nil {
+		return nil, emittedKeys{}, err
+	}
+	if len(emitted) != len(keys) {
+		return nil, emittedKeys{}, errors.E(
+			ErrDedupStoreResultsMismatch,
+			errors.KV("keys", len(keys)),
+			errors.KV("results", len(emitted)),
+		)
+	}
+
+	newDocuments := make([]Document, 0, len(documents))
+	newKeys := make([]string, 0, len(keys))
+	for i, document := range documents {
+		if !emitted[i] {
+			newDocuments = append(newDocuments, document)
+			newKeys = append(newKeys, keys[i])
+		}
+	}
+	return newDocuments, emittedKeys{store: dedup.store, keys: newKeys}, nil
+}
+
+// dedupKeys returns the DedupStore keys of the documents of the Namespace.
+// Keys are prefixed by the Namespace, so a DedupStore can be shared by many
+// namespaces.
+func dedupKeys(namespace Namespace, key DocumentKeyFunc, documents []Document) ([]string, error) {
+	keys := make([]string, len(documents))
+	for i, document := range documents {
+		documentKey, err := key(document)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = string(namespace) + "\x00" + documentKey
+	}
+	return keys, nil
+}
+
+// CommitDocumentsByNamespace implements ParallelProcessor.
+func (p *parallelProcessor) CommitDocumentsByNamespace(
+	ctx context.Context,
+	documentsByNamespace map[Namespace][]Document,
+) error {
+	const op = errors.Op("gomsgprocessor.parallelProcessor.CommitDocumentsByNamespace")
+
+	emitted := make([]emittedKeys, 0, len(documentsByNamespace))
+	for namespace, documents := range documentsByNamespace {
+		dedup, ok := p.documentDedupFor(namespace)
+		if !ok {
+			continue
+		}
+
+		keys, err := dedupKeys(namespace, dedup.key, documents)
+		if err != nil {
+			return errors.E(op, err, ErrCodeDeduplicateDocuments)
+		}
+		emitted = append(emitted, emittedKeys{store: dedup.store, keys: keys})
+	}
+
+	err := addEmittedKeys(ctx, emitted...)
+	if err != nil {
+		return errors.E(op, err, ErrCodeDeduplicateDocuments)
+	}
+	return nil
+}
+
+// MemoryDedupStore is a DedupStore that keeps the keys in memory, evicting the
+// least recently used ones when it is full.
+type MemoryDedupStore struct {
+	capacity int
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type memoryDedupEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// NewMemoryDedupStore returns a MemoryDedupStore that holds up to capacity
+// keys, each one for ttl after it was added. A capacity lower than 1 disables
+// the eviction and a ttl lower than 1 keeps keys until they are evicted.
+func NewMemoryDedupStore(capacity int, ttl time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		capacity: capacity,
+		ttl:      ttl,
+		now:      time.Now,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Contains implements DedupStore. Found keys become the most recently used.
+func (s *MemoryDedupStore) Contains(_ context.Context, keys []string) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		element, ok := s.entries[key]
+		if !ok {
+			continue
+		}
+
+		entry := element.Value.(*memoryDedupEntry)
+		if s.ttl > 0 && !now.Before(entry.expiresAt) {
+			s.removeElement(element)
+			continue
+		}
+
+		s.lru.MoveToFront(element)
+		found[i] = true
+	}
+	return found, nil
+}
+
+// Add implements DedupStore.
+func (s *MemoryDedupStore) Add(_ context.Context, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	expiresAt := now.Add(s.ttl)
+	for _, key := range keys {
+		if element, ok := s.entries[key]; ok {
+			element.Value.(*memoryDedupEntry).expiresAt = expiresAt
+			s.lru.MoveToFront(element)
+			continue
+		}
+		s.entries[key] = s.lru.PushFront(&memoryDedupEntry{key: key, expiresAt: expiresAt})
+	}
+
+	for s.capacity > 0 && s.lru.Len() > s.capacity {
+		s.removeElement(s.lru.Back())
+	}
+
+	// Expired keys are also removed from the least recently used ones, so the
+	// store does not grow with keys that are never looked up again.
+	for oldest := s.lru.Back(); s.ttl > 0 && oldest != nil; oldest = s.lru.Back() {
+		if now.Before(oldest.Value.(*memoryDedupEntry).expiresAt) {
+			break
+		}
+		s.removeElement(oldest)
+	}
+	return nil
+}
+
+func (s *MemoryDedupStore) removeElement(element *list.Element) {
+	s.lru.Remove(element)
+	delete(s.entries, element.Value.(*memoryDedupEntry).key)
+}
+
+// Len returns the number of keys in the store, including the expired ones not
+// removed yet.
+func (s *MemoryDedupStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lru.Len()
+}
@@ -0,0 +1,273 @@
+package gomsgprocessor
+
+import (
+	"bufio"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// minFileDedupStoreCompaction is the minimum number of lines of a
+	// FileDedupStore's file before its expired keys are removed and it is
+	// compacted.
+	minFileDedupStoreCompaction = 1024
+	// fileDedupStoreCompactionRatio is how many lines the file may have for
+	// each key in the store before it is compacted.
+	fileDedupStoreCompactionRatio = 2
+	// maxFileDedupStoreLineSize is the maximum size of a line of a
+	// FileDedupStore's file.
+	maxFileDedupStoreLineSize = 1 << 20
+)
+
+// FileDedupStore is a DedupStore persisted in a single append-only file, so
+// the keys survive restarts. All keys are also kept in memory. The file is
+// compacted when most of its lines are stale.
+//
+// Each line holds the expiration, in Unix nanoseconds, and the quoted key.
+type FileDedupStore struct {
+	path string
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu        sync.Mutex
+	file      *os.File
+	writer    *bufio.Writer
+	expiresAt map[string]int64
+	lines     int
+	// compactAt is the number of lines at which the expired keys are removed
+	// and the file is compacted if most of its lines are stale.
+	compactAt int
+}
+
+// OpenFileDedupStore opens the FileDedupStore at path, creating it if needed.
+// Each key is kept for ttl after it was added, and a ttl lower than 1 keeps
+// keys forever. Close must be called to close the file.
+func OpenFileDedupStore(path string, ttl time.Duration) (*FileDedupStore, error) {
+	return openFileDedupStore(path, ttl, time.Now)
+}
+
+func openFileDedupStore(path string, ttl time.Duration, now func() time.Time) (*FileDedupStore, error) {
+	const op = errors.Op("gomsgprocessor.OpenFileDedupStore")
+
+	s := &FileDedupStore{
+		path:      path,
+		ttl:       ttl,
+		now:       now,
+		expiresAt: make(map[string]int64),
+	}
+
+	err := s.load()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	err = s.open()
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	s.compactAt = max(minFileDedupStoreCompaction, fileDedupStoreCompactionRatio*len(s.expiresAt))
+	return s, nil
+}
+
+// Contains implements DedupStore.
+func (s *FileDedupStore) Contains(_ context.Context, keys []string) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now().UnixNano()
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		expiresAt, ok := s.expiresAt[key]
+		if !ok {
+			continue
+		}
+		if expiresAt > 0 && now >= expiresAt {
+			delete(s.expiresAt, key)
+			continue
+		}
+		found[i] = true
+	}
+	return found, nil
+}
+
+// Add implements DedupStore. The keys are written to the file before it
+// returns.
+func (s *FileDedupStore) Add(ctx context.Context, keys []string) error {
+	const op = errors.Op("gomsgprocessor.FileDedupStore.Add")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var expiresAt int64
+	if s.ttl > 0 {
+		expiresAt = now.Add(s.ttl).UnixNano()
+	}
+
+	for _, key := range keys {
+		_, err := fmt.Fprintf(s.writer, "%d\t%s\n", expiresAt, strconv.Quote(key))
+		if err != nil {
+			return errors.E(op, err)
+		}
+	}
+	err := s.writer.Flush()
+	if err != nil {
+		return errors.E(op, err)
+	}
+
+	for _, key := range keys {
+		s.expiresAt[key] = expiresAt
+	}
+	s.lines += len(keys)
+
+	if s.lines > s.compactAt {
+		s.removeExpired(now.UnixNano())
+		if s.lines > fileDedupStoreCompactionRatio*len(s.expiresAt) {
+			// The keys are already written, so a failed compaction is only
+			// retried when the file grows again.
+			err = s.compact()
+			if err != nil {
+				log.Ctx(ctx).Warn().Err(err).Str("path", s.path).Msg("Failed to compact the dedup store file...")
+			}
+		}
+		// The next check waits for the file to grow in proportion, so
+		// removing the expired keys stays cheap for each Add.
+		s.compactAt = max(minFileDedupStoreCompaction, fileDedupStoreCompactionRatio*s.lines)
+	}
+	return nil
+}
+
+// removeExpired removes the expired keys from memory. It must be called with
+// mu locked.
+func (s *FileDedupStore) removeExpired(now int64) {
+	for key, expiresAt := range s.expiresAt {
+		if expiresAt > 0 && now >= expiresAt {
+			delete(s.expiresAt, key)
+		}
+	}
+}
+
+// Close closes the file.
+func (s *FileDedupStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.writer.Flush()
+	if err != nil {
+		_ = s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// load reads the keys not expired yet from the file. Malformed lines, like the
+// last one of an interrupted write, are skipped.
+func (s *FileDedupStore) load() error {
+	f, err := os.Open(s.path)
+	if stderrors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := s.now().UnixNano()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxFileDedupStoreLineSize)
+	for scanner.Scan() {
+		s.lines++
+
+		rawExpiresAt, rawKey, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		expiresAt, err := strconv.ParseInt(rawExpiresAt, 10, 64)
+		if err != nil {
+			continue
+		}
+		key, err := strconv.Unquote(rawKey)
+		if err != nil {
+			continue
+		}
+
+		if expiresAt > 0 && now >= expiresAt {
+			delete(s.expiresAt, key)
+			continue
+		}
+		s.expiresAt[key] = expiresAt
+	}
+	return scanner.Err()
+}
+
+// open opens the file for appending.
+func (s *FileDedupStore) open() error {
+	//nolint:gosec // the path is chosen by the caller on purpose.
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	s.file = f
+	s.writer = bufio.NewWriter(f)
+	return nil
+}
+
+// compact rewrites the file with only the keys not expired yet and replaces
+// the current one with it. If it fails, the current file is kept. It must be
+// called with mu locked.
+func (s *FileDedupStore) compact() error {
+	tmpPath := s.path + ".tmp"
+	//nolint:gosec // the path is chosen by the caller on purpose.
+	tmp, err := os.OpenFile(tmpPath, os.O_APPEND|os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	now := s.now().UnixNano()
+	writer := bufio.NewWriter(tmp)
+	lines := 0
+	for key, expiresAt := range s.expiresAt {
+		if expiresAt > 0 && now >= expiresAt {
+			delete(s.expiresAt, key)
+			continue
+		}
+		_, err = fmt.Fprintf(writer, "%d\t%s\n", expiresAt, strconv.Quote(key))
+		if err != nil {
+			break
+		}
+		lines++
+	}
+
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, s.path)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	// The old file was replaced, so it only needs to be closed. The new one is
+	// kept open for appending.
+	_ = s.file.Close()
+	s.file = tmp
+	s.writer = writer
+	s.lines = lines
+	return nil
+}
@@ -0,0 +1,274 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MakeDocumentsByNamespace_DedupStore(t *testing.T) {
+	t.Parallel()
+
+	documentID := func(document Document) (string, error) {
+		return document.(mockDocument).id, nil
+	}
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{"type-1": newConcurrencyTrackingBuilder()},
+		WithDedupStoreOption(NewMemoryDedupStore(100, time.Hour), documentID, "tiramisu"),
+	)
+
+	documents, err := parallelProcessor.MakeDocumentsByNamespace(context.Background(), []Message{
+		&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "2", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "1", namespace: "potato", messageType: "type-1"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[Namespace][]Document{
+		"tiramisu": {mockDocument{id: "1"}, mockDocument{id: "2"}},
+		"potato":   {mockDocument{id: "1"}},
+	}, documents)
+	assert.NoError(t, parallelProcessor.CommitDocumentsByNamespace(context.Background(), documents))
+
+	documents, err = parallelProcessor.MakeDocumentsByNamespace(context.Background(), []Message{
+		&mockMessage{id: "2", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "3", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "1", namespace: "potato", messageType: "type-1"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[Namespace][]Document{
+		"tiramisu": {mockDocument{id: "3"}},
+		"potato":   {mockDocument{id: "1"}},
+	}, documents)
+	assert.NoError(t, parallelProcessor.CommitDocumentsByNamespace(context.Background(), documents))
+
+	documents, err = parallelProcessor.MakeDocumentsByNamespace(context.Background(), []Message{
+		&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, documents)
+}
+
+func Test_Process_DedupStore_Commit(t *testing.T) {
+	t.Parallel()
+
+	documentID := func(document Document) (string, error) {
+		return document.(mockDocument).id, nil
+	}
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{"type-1": newConcurrencyTrackingBuilder()},
+		WithDedupStoreOption(NewMemoryDedupStore(100, time.Hour), documentID),
+	)
+	msgs := []Message{
+		&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "1", namespace: "potato", messageType: "type-1"},
+	}
+
+	// The documents are not committed, as if writing them failed, so the
+	// redelivered messages build them again.
+	result, err := parallelProcessor.Process(context.Background(), msgs)
+	assert.NoError(t, err)
+	assert.Len(t, result.Documents, 2)
+
+	result, err = parallelProcessor.Process(context.Background(), msgs)
+	assert.NoError(t, err)
+	assert.Len(t, result.Documents, 2)
+	assert.NoError(t, result.Commit(context.Background()))
+
+	result, err = parallelProcessor.Process(context.Background(), msgs)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Documents)
+}
+
+func Test_CommitDocumentsByNamespace_Failure(t *testing.T) {
+	t.Parallel()
+
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{"type-1": newConcurrencyTrackingBuilder()},
+		WithDedupStoreOption(NewMemoryDedupStore(100, time.Hour), func(Document) (string, error) {
+			return "", errors.New("no key")
+		}),
+	)
+
+	err := parallelProcessor.CommitDocumentsByNamespace(context.Background(), map[Namespace][]Document{
+		"tiramisu": {mockDocument{id: "1"}},
+	})
+	assert.EqualError(t, err, "gomsgprocessor.parallelProcessor.CommitDocumentsByNamespace: no key")
+	assert.Equal(t, ErrCodeDeduplicateDocuments, errors.GetCode(err))
+}
+
+func Test_DocumentContentHash(t *testing.T) {
+	t.Parallel()
+
+	type exported struct {
+		ID string
+	}
+	type unexported struct {
+		id string
+	}
+
+	first, err := DocumentContentHash(exported{ID: "1"})
+	assert.NoError(t, err)
+	second, err := DocumentContentHash(exported{ID: "2"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	_, err = DocumentContentHash(unexported{id: "1"})
+	assert.ErrorIs(t, err, ErrEmptyDocumentContent)
+
+	_, err = DocumentContentHash(nil)
+	assert.ErrorIs(t, err, ErrEmptyDocumentContent)
+}
+
+func Test_StreamProcessor_DedupStore_Redelivery(t *testing.T) {
+	t.Parallel()
+
+	documentID := func(document Document) (string, error) {
+		return document.(mockDocument).id, nil
+	}
+	streamProcessor := NewStreamProcessor(
+		map[MessageType]DocumentBuilder{"type-1": newConcurrencyTrackingBuilder()},
+		WithDedupStoreOption(NewMemoryDedupStore(100, time.Hour), documentID),
+	)
+	msgs := []Message{&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"}}
+
+	deliver := func(handleErr error) (int, error) {
+		documents := 0
+		err := streamProcessor.ProcessSeq(
+			context.Background(),
+			slices.Values(msgs),
+			func(_ context.Context, batch Batch) error {
+				documents += len(batch.Documents)
+				return handleErr
+			},
+		)
+		return documents, err
+	}
+
+	// The handler fails, so the redelivered Message is emitted again.
+	documents, err := deliver(errors.New("sink is down"))
+	assert.Error(t, err)
+	assert.Equal(t, 1, documents)
+
+	documents, err = deliver(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, documents)
+
+	documents, err = deliver(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, documents)
+}
+
+func Test_MemoryDedupStore(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryDedupStore(2, time.Minute)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	assert.NoError(t, store.Add(ctx, []string{"a", "b"}))
+	found, err := store.Contains(ctx, []string{"a", "b", "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, true, false}, found)
+
+	// "b" is the least recently used, so it is evicted.
+	_, _ = store.Contains(ctx, []string{"a"})
+	assert.NoError(t, store.Add(ctx, []string{"c"}))
+	found, _ = store.Contains(ctx, []string{"a", "b", "c"})
+	assert.Equal(t, []bool{true, false, true}, found)
+	assert.Equal(t, 2, store.Len())
+
+	now = now.Add(time.Minute)
+	found, _ = store.Contains(ctx, []string{"a", "c"})
+	assert.Equal(t, []bool{false, false}, found)
+	assert.Equal(t, 0, store.Len())
+}
+
+func Test_MemoryDedupStore_RemovesExpiredKeys(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryDedupStore(0, time.Nanosecond)
+	store.now = func() time.Time { return now }
+
+	// Keys are never looked up again, so they are only removed by Add.
+	for i := range 10000 {
+		now = now.Add(time.Second)
+		assert.NoError(t, store.Add(context.Background(), []string{fmt.Sprint(i)}))
+	}
+	assert.Equal(t, 1, store.Len())
+}
+
+func Test_FileDedupStore(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "dedup")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	ctx := context.Background()
+
+	store, err := openFileDedupStore(path, time.Minute, clock)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Add(ctx, []string{"a", "tiramisu\x00b\nc"}))
+	now = now.Add(30 * time.Second)
+	require.NoError(t, store.Add(ctx, []string{"d"}))
+	require.NoError(t, store.Close())
+
+	// Keys survive reopening the store, until they expire.
+	now = now.Add(45 * time.Second)
+	store, err = openFileDedupStore(path, time.Minute, clock)
+	require.NoError(t, err)
+
+	found, err := store.Contains(ctx, []string{"a", "tiramisu\x00b\nc", "d"})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{false, false, true}, found)
+
+	// Rewriting the same keys compacts the file.
+	for i := range minFileDedupStoreCompaction {
+		require.NoError(t, store.Add(ctx, []string{fmt.Sprint(i % 10)}))
+	}
+	require.NoError(t, store.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Less(t, len(content), 100*minFileDedupStoreCompaction/10)
+
+	// The compacted file keeps the keys.
+	store, err = openFileDedupStore(path, time.Minute, clock)
+	require.NoError(t, err)
+
+	found, err = store.Contains(ctx, []string{"0", "9", "d"})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, true, true}, found)
+	require.NoError(t, store.Close())
+}
+
+func Test_FileDedupStore_RemovesExpiredKeys(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "dedup")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	ctx := context.Background()
+
+	store, err := openFileDedupStore(path, time.Nanosecond, clock)
+	require.NoError(t, err)
+
+	// Keys are never looked up again, so they are only removed by Add.
+	for i := range 5 * minFileDedupStoreCompaction {
+		now = now.Add(time.Second)
+		require.NoError(t, store.Add(ctx, []string{fmt.Sprint(i)}))
+	}
+	assert.LessOrEqual(t, len(store.expiresAt), minFileDedupStoreCompaction)
+	assert.LessOrEqual(t, store.lines, minFileDedupStoreCompaction)
+	require.NoError(t, store.Close())
+}
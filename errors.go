@@ -13,3 +13,71 @@ var ErrCodeDeduplicateDocuments = errors.Code("FAILED_DEDUPLICATE_DOCUMENTS")
 // ErrMsgTypeHasNoBuilder is returned when MessageType has no DocumentBuilder
 // associated with it.
 var ErrMsgTypeHasNoBuilder = errors.New("message type has no document builder")
+
+// ErrCodeMsgTypeHasNoBuilder is the (foundationkit/errors).Code of
+// ErrMsgTypeHasNoBuilder.
+var ErrCodeMsgTypeHasNoBuilder = errors.Code("MESSAGE_TYPE_HAS_NO_BUILDER")
+
+// ErrUnexpectedMessageType is returned when a TypedDocumentBuilder receives a
+// Message that is not of its message type.
+var ErrUnexpectedMessageType = errors.New("unexpected message type")
+
+// ErrUnexpectedDocumentType is returned when a Document is not of the document
+// type expected by a typed API.
+var ErrUnexpectedDocumentType = errors.New("unexpected document type")
+
+// ErrCodeHandleBatch is returned when the BatchHandler of a StreamProcessor
+// failed to handle a Batch.
+var ErrCodeHandleBatch = errors.Code("FAILED_HANDLE_BATCH")
+
+// ErrCodeBuildTimeout is returned when a DocumentBuilder's Build did not finish
+// within the timeout set by WithBuildTimeoutOption or
+// WithMessageTypeBuildTimeoutOption.
+var ErrCodeBuildTimeout = errors.Code("BUILD_TIMEOUT")
+
+// ErrCodeExpandMessages is returned when the child messages of an
+// ExpandingDocumentBuilder could not be built.
+var ErrCodeExpandMessages = errors.Code("FAILED_EXPAND_MESSAGES")
+
+// ErrMaxExpansionDepthExceeded is returned when a child Message is deeper than
+// the maximum expansion depth.
+var ErrMaxExpansionDepthExceeded = errors.New("max message expansion depth exceeded")
+
+// ErrMessageExpansionCycle is returned when a child Message has the same key
+// as one of the messages it descends from.
+var ErrMessageExpansionCycle = errors.New("message expansion cycle")
+
+// ErrCodeInvalidMessage is returned when a Message is rejected by
+// ValidateMessageMiddleware.
+var ErrCodeInvalidMessage = errors.Code("INVALID_MESSAGE")
+
+// ErrCodeInvalidDocument is returned when a Document is rejected by
+// ValidateDocumentsMiddleware.
+var ErrCodeInvalidDocument = errors.Code("INVALID_DOCUMENT")
+
+// ErrBuildPanic is returned when a DocumentBuilder panics while building a
+// Message. The panic value and the stack trace are attached as KV.
+var ErrBuildPanic = errors.New("document builder panicked")
+
+// ErrCodeBuildPanic is the (foundationkit/errors).Code of ErrBuildPanic.
+var ErrCodeBuildPanic = errors.Code("BUILD_PANIC")
+
+// ErrCodeDeadLetter is returned when a Message failed to build and the
+// DeadLetterSink failed to store it.
+var ErrCodeDeadLetter = errors.Code("FAILED_DEAD_LETTER")
+
+// ErrBatchResultsMismatch is returned when a BatchDocumentBuilder returns a
+// number of BatchBuildResult different from the number of messages.
+var ErrBatchResultsMismatch = errors.New("batch document builder returned a wrong number of results")
+
+// ErrLoaderKeyNotFound is returned by a Loader when its BatchLoadFunc returns
+// no value for a key.
+var ErrLoaderKeyNotFound = errors.New("loader key not found")
+
+// ErrEmptyDocumentContent is returned by DocumentContentHash when a Document
+// is encoded without any field, like one with only unexported fields.
+var ErrEmptyDocumentContent = errors.New("document has no content to hash")
+
+// ErrDedupStoreResultsMismatch is returned when a DedupStore's Contains returns
+// a number of results different from the number of keys.
+var ErrDedupStoreResultsMismatch = errors.New("dedup store returned a wrong number of results")
@@ -0,0 +1,117 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"slices"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// defaultMaxExpansionDepth is how many levels of child messages are built when
+// WithMessageExpansionOption is not set.
+const defaultMaxExpansionDepth = 10
+
+// ExpandingDocumentBuilder is a DocumentBuilder that, besides documents, may
+// emit child messages. When a DocumentBuilder implements this interface, the
+// processor calls BuildWithChildren instead of Build and feeds the child
+// messages back through the map of DocumentBuilder until no more messages
+// remain (see WithMessageExpansionOption).
+type ExpandingDocumentBuilder interface {
+	DocumentBuilder
+
+	// BuildWithChildren transforms a Message into []Document and child
+	// []Message.
+	BuildWithChildren(context.Context, Message) ([]Document, []Message, error)
+}
+
+// expansionConfig holds the guards used when building child messages.
+type expansionConfig struct {
+	maxDepth int
+	key      MessageKeyFunc
+}
+
+// checkExpansion fails if the builtMessage is deeper than allowed or if its
+// key was already seen in its ancestors.
+func (p *parallelProcessor) checkExpansion(builtMsg *builtMessage) error {
+	if builtMsg.depth > p.expansion.maxDepth {
+		return errors.E(
+			ErrMaxExpansionDepthExceeded,
+			ErrCodeExpandMessages,
+			errors.KV("depth", builtMsg.depth),
+		)
+	}
+
+	if p.expansion.key != nil && builtMsg.depth > 0 {
+		key := p.expansion.key(builtMsg.message)
+		if slices.Contains(builtMsg.ancestors, key) {
+			return errors.E(
+				ErrMessageExpansionCycle,
+				ErrCodeExpandMessages,
+				errors.KV("key", key),
+			)
+		}
+	}
+
+	return nil
+}
+
+// buildMessageTree builds the given builtMessage and all its descendants, one
+// at a time, stopping at the first failure.
+func (p *parallelProcessor) buildMessageTree(ctx context.Context, builtMsg *builtMessage) error {
+	err := p.buildMessage(ctx, builtMsg)
+	if err != nil {
+		return err
+	}
+
+	for i := range builtMsg.children {
+		err = p.buildMessageTree(ctx, &builtMsg.children[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newChildren returns one builtMessage, still to be built, for each child
+// Message of the given builtMessage.
+func (p *parallelProcessor) newChildren(parent *builtMessage, msgs []Message) []builtMessage {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	var ancestors []string
+	if p.expansion.key != nil {
+		ancestors = append(slices.Clip(parent.ancestors), p.expansion.key(parent.message))
+	}
+
+	children := make([]builtMessage, len(msgs))
+	for i, msg := range msgs {
+		children[i] = builtMessage{
+			message:   msg,
+			index:     parent.index,
+			depth:     parent.depth + 1,
+			ancestors: ancestors,
+		}
+	}
+	return children
+}
+
+// childrenOf returns the children of all given builtMessage, in order.
+func childrenOf(level []*builtMessage) []*builtMessage {
+	var children []*builtMessage
+	for _, builtMsg := range level {
+		for i := range builtMsg.children {
+			children = append(children, &builtMsg.children[i])
+		}
+	}
+	return children
+}
+
+// walkBuiltMessages calls fn for every builtMessage and its descendants, each
+// parent before its children.
+func walkBuiltMessages(builtMessages []builtMessage, fn func(*builtMessage)) {
+	for i := range builtMessages {
+		fn(&builtMessages[i])
+		walkBuiltMessages(builtMessages[i].children, fn)
+	}
+}
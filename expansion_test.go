@@ -0,0 +1,114 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MakeDocuments_Expansion(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+
+		builder ExpandingDocumentBuilder
+		opts    []Option
+
+		expectedResponse []Document
+		expectedFailures int
+	}{
+		{
+			name:    "success - children are built after their parent",
+			builder: countdownDocumentBuilder{},
+			expectedResponse: []Document{
+				mockDocument{id: "2"},
+				mockDocument{id: "1"},
+				mockDocument{id: "0"},
+				mockDocument{id: "1"},
+				mockDocument{id: "0"},
+			},
+		},
+		{
+			name:    "errors - max depth exceeded",
+			builder: countdownDocumentBuilder{},
+			opts:    []Option{WithMessageExpansionOption(1, nil)},
+			expectedResponse: []Document{
+				mockDocument{id: "2"},
+				mockDocument{id: "1"},
+				mockDocument{id: "1"},
+				mockDocument{id: "0"},
+			},
+			expectedFailures: 1,
+		},
+		{
+			name:    "errors - cycle",
+			builder: countdownDocumentBuilder{},
+			opts: []Option{
+				WithMessageExpansionOption(10, func(msg Message) string {
+					return string(msg.GetNamespace())
+				}),
+			},
+			expectedResponse: []Document{
+				mockDocument{id: "2"},
+				mockDocument{id: "1"},
+			},
+			expectedFailures: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			parallelProcessor := NewParallelProcessor(
+				map[MessageType]DocumentBuilder{
+					"countdown": test.builder,
+				},
+				test.opts...,
+			)
+
+			result, err := parallelProcessor.Process(
+				context.Background(),
+				[]Message{
+					&mockMessage{id: "2", namespace: "tiramisu", messageType: "countdown"},
+					&mockMessage{id: "1", namespace: "tiramisu", messageType: "countdown"},
+				},
+			)
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedResponse, result.Documents)
+			assert.Len(t, result.Failures, test.expectedFailures)
+			for _, failure := range result.Failures {
+				assert.Equal(t, ErrCodeExpandMessages, failure.Code)
+			}
+		})
+	}
+}
+
+// countdownDocumentBuilder builds a document for a message with a numeric id
+// and emits a child message with the id decremented, until it reaches zero.
+type countdownDocumentBuilder struct{}
+
+func (b countdownDocumentBuilder) Build(ctx context.Context, msg Message) ([]Document, error) {
+	documents, _, err := b.BuildWithChildren(ctx, msg)
+	return documents, err
+}
+
+func (countdownDocumentBuilder) BuildWithChildren(
+	_ context.Context,
+	msg Message,
+) ([]Document, []Message, error) {
+	m := msg.(*mockMessage)
+	documents := []Document{mockDocument{id: m.id}}
+
+	n, err := strconv.Atoi(m.id)
+	if err != nil || n == 0 {
+		return documents, nil, err
+	}
+
+	return documents, []Message{
+		&mockMessage{id: strconv.Itoa(n - 1), namespace: m.namespace, messageType: m.messageType},
+	}, nil
+}
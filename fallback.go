@@ -0,0 +1,41 @@
+package gomsgprocessor
+
+// unknownMessageTypeFallback is what the processor does with a Message whose
+// MessageType has no DocumentBuilder. The zero value fails the Message with
+// ErrMsgTypeHasNoBuilder.
+type unknownMessageTypeFallback struct {
+	// builder, when set, builds the Message.
+	builder DocumentBuilder
+	// skip ignores the Message.
+	skip bool
+	// deadLetterSink, when set, receives the Message.
+	deadLetterSink DeadLetterSink
+}
+
+// unknownMessageTypeFallbackFor returns the fallback registered for the
+// Namespace, falling back to the processor's default one.
+func (p *parallelProcessor) unknownMessageTypeFallbackFor(namespace Namespace) unknownMessageTypeFallback {
+	if fallback, ok := p.unknownMessageTypeFallbackByNamespace[namespace]; ok {
+		return fallback
+	}
+	return p.unknownMessageTypeFallback
+}
+
+// setUnknownMessageTypeFallback registers the fallback for the given
+// namespaces, or as the default one if there is none.
+func (p *parallelProcessor) setUnknownMessageTypeFallback(
+	fallback unknownMessageTypeFallback,
+	namespaces []Namespace,
+) {
+	if len(namespaces) == 0 {
+		p.unknownMessageTypeFallback = fallback
+		return
+	}
+
+	if p.unknownMessageTypeFallbackByNamespace == nil {
+		p.unknownMessageTypeFallbackByNamespace = make(map[Namespace]unknownMessageTypeFallback)
+	}
+	for _, namespace := range namespaces {
+		p.unknownMessageTypeFallbackByNamespace[namespace] = fallback
+	}
+}
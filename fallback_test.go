@@ -0,0 +1,110 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Process_UnknownMessageTypeFallback(t *testing.T) {
+	t.Parallel()
+
+	msgs := []Message{
+		&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "2", namespace: "tiramisu", messageType: "unknown"},
+		&mockMessage{id: "3", namespace: "potato", messageType: "unknown"},
+	}
+
+	tests := []struct {
+		name string
+
+		opts []Option
+		sink *InMemoryDeadLetterSink
+
+		expectedDocuments   []Document
+		expectedIgnored     []IgnoredMessage
+		expectedFailures    []int
+		expectedDeadLetters []Message
+	}{
+		{
+			name:              "no fallback",
+			expectedDocuments: []Document{mockDocument{id: "1"}},
+			expectedFailures:  []int{1, 2},
+		},
+		{
+			name: "default builder",
+			opts: []Option{
+				WithDefaultDocumentBuilderOption(newConcurrencyTrackingBuilder()),
+			},
+			expectedDocuments: []Document{mockDocument{id: "1"}, mockDocument{id: "2"}, mockDocument{id: "3"}},
+		},
+		{
+			name: "default builder by namespace",
+			opts: []Option{
+				WithDefaultDocumentBuilderOption(newConcurrencyTrackingBuilder(), "potato"),
+			},
+			expectedDocuments: []Document{mockDocument{id: "1"}, mockDocument{id: "3"}},
+			expectedFailures:  []int{1},
+		},
+		{
+			name: "skip",
+			opts: []Option{
+				WithSkipUnknownMessageTypesOption(),
+			},
+			expectedDocuments: []Document{mockDocument{id: "1"}},
+			expectedIgnored: []IgnoredMessage{
+				{Index: 1, Message: msgs[1], Reason: ErrMsgTypeHasNoBuilder.Error()},
+				{Index: 2, Message: msgs[2], Reason: ErrMsgTypeHasNoBuilder.Error()},
+			},
+		},
+		{
+			name: "skip and dead letter by namespace",
+			opts: []Option{
+				WithSkipUnknownMessageTypesOption("tiramisu"),
+			},
+			sink:              NewInMemoryDeadLetterSink(),
+			expectedDocuments: []Document{mockDocument{id: "1"}},
+			expectedIgnored: []IgnoredMessage{
+				{Index: 1, Message: msgs[1], Reason: ErrMsgTypeHasNoBuilder.Error()},
+			},
+			expectedDeadLetters: []Message{msgs[2]},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := test.opts
+			if test.sink != nil {
+				opts = append(opts, WithDeadLetterUnknownMessageTypesOption(test.sink, "potato"))
+			}
+			parallelProcessor := NewParallelProcessor(
+				map[MessageType]DocumentBuilder{"type-1": newConcurrencyTrackingBuilder()},
+				opts...,
+			)
+
+			result, err := parallelProcessor.Process(context.Background(), msgs)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedDocuments, result.Documents)
+			assert.Equal(t, test.expectedIgnored, result.Ignored)
+
+			var failures []int
+			for _, failure := range result.Failures {
+				assert.Equal(t, ErrCodeMsgTypeHasNoBuilder, failure.Code)
+				failures = append(failures, failure.Index)
+			}
+			assert.Equal(t, test.expectedFailures, failures)
+
+			if test.sink != nil {
+				var deadLetters []Message
+				for _, deadLetter := range test.sink.DeadLetters() {
+					assert.Equal(t, ErrCodeMsgTypeHasNoBuilder, deadLetter.Metadata.Code)
+					deadLetters = append(deadLetters, deadLetter.Message)
+				}
+				assert.Equal(t, test.expectedDeadLetters, deadLetters)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package gomsgprocessor
+
+import (
+	"context"
+	stderrors "errors"
+)
+
+// IgnoredMessage describes a Message that built no documents nor child
+// messages.
+type IgnoredMessage struct {
+	// Index is the position of the Message in the given []Message. For child
+	// messages of an ExpandingDocumentBuilder, it is the position of the input
+	// Message they descend from.
+	Index   int
+	Message Message
+	// Reason is the one given to IgnoreMessage, or empty if the
+	// DocumentBuilder just returned no documents.
+	Reason string
+}
+
+// IgnoredMessageHook is called for each ignored Message. It may be called
+// concurrently.
+//
+// See WithIgnoredMessageHookOption.
+type IgnoredMessageHook func(context.Context, IgnoredMessage)
+
+// IgnoreMessage returns an error that a DocumentBuilder can return to ignore
+// the Message with the given reason. It is not a failure: the Message is
+// reported as ignored and is never retried.
+func IgnoreMessage(reason string) error {
+	return &ignoredMessageError{reason: reason}
+}
+
+type ignoredMessageError struct {
+	reason string
+}
+
+func (e *ignoredMessageError) Error() string {
+	return "message ignored: " + e.reason
+}
+
+// ignoreReason returns the reason of an error returned by IgnoreMessage.
+func ignoreReason(err error) (string, bool) {
+	var ignoredErr *ignoredMessageError
+	if stderrors.As(err, &ignoredErr) {
+		return ignoredErr.reason, true
+	}
+	return "", false
+}
+
+// ignoreMessage records that the given builtMessage was ignored.
+func (p *parallelProcessor) ignoreMessage(ctx context.Context, builtMsg *builtMessage, reason string) {
+	builtMsg.ignored = true
+	builtMsg.ignoreReason = reason
+
+	p.metrics.CountMessage(ctx, builtMsg.message.GetType(), MessageStatusIgnored)
+	if p.ignoredMessageHook != nil {
+		p.ignoredMessageHook(ctx, IgnoredMessage{
+			Index:   builtMsg.index,
+			Message: builtMsg.message,
+			Reason:  reason,
+		})
+	}
+}
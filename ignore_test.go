@@ -0,0 +1,59 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Process_IgnoredMessages(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	builder := DocumentBuilderFunc(func(_ context.Context, msg Message) ([]Document, error) {
+		calls.Add(1)
+		switch id := msg.(*mockMessage).id; id {
+		case "stale":
+			return nil, IgnoreMessage("stale message")
+		case "empty":
+			return nil, nil
+		default:
+			return []Document{mockDocument{id: id}}, nil
+		}
+	})
+
+	var (
+		mu     sync.Mutex
+		hooked []IgnoredMessage
+	)
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{"type-1": builder},
+		WithRetryPolicyOption(RetryPolicy{MaxAttempts: 3}),
+		WithIgnoredMessageHookOption(func(_ context.Context, ignored IgnoredMessage) {
+			mu.Lock()
+			defer mu.Unlock()
+			hooked = append(hooked, ignored)
+		}),
+	)
+
+	msgs := []Message{
+		&mockMessage{id: "stale", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "empty", namespace: "tiramisu", messageType: "type-1"},
+	}
+	result, err := parallelProcessor.Process(context.Background(), msgs)
+	assert.NoError(t, err)
+	assert.Equal(t, []Document{mockDocument{id: "1"}}, result.Documents)
+	assert.Empty(t, result.Failures)
+
+	expectedIgnored := []IgnoredMessage{
+		{Index: 0, Message: msgs[0], Reason: "stale message"},
+		{Index: 2, Message: msgs[2]},
+	}
+	assert.Equal(t, expectedIgnored, result.Ignored)
+	assert.ElementsMatch(t, expectedIgnored, hooked)
+	assert.Equal(t, int32(3), calls.Load(), "ignored messages must not be retried")
+}
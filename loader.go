@@ -0,0 +1,256 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// defaultLoaderWait is the default LoaderConfig's Wait.
+const defaultLoaderWait = time.Millisecond
+
+// BatchLoadFunc fetches the values of many keys at once. Keys without a value
+// in the returned map fail with ErrLoaderKeyNotFound.
+type BatchLoadFunc[K comparable, V any] func(context.Context, []K) (map[K]V, error)
+
+// LoaderConfig configures how a Loader groups keys in batches.
+type LoaderConfig struct {
+	// MaxBatchSize is the maximum number of keys given to each BatchLoadFunc
+	// call. Values lower than 1 disable the limit.
+	MaxBatchSize int
+	// Wait is how long a Loader waits for more keys before calling the
+	// BatchLoadFunc. Values lower than 1 default to one millisecond.
+	Wait time.Duration
+}
+
+// LoaderKey identifies a Loader registered with WithLoaderOption. Use it in a
+// DocumentBuilder to get the Loader of the current call with
+// LoaderFromContext.
+type LoaderKey[K comparable, V any] struct {
+	name string
+}
+
+// NewLoaderKey returns a new LoaderKey. The name is only used in errors.
+func NewLoaderKey[K comparable, V any](name string) *LoaderKey[K, V] {
+	return &LoaderKey[K, V]{name: name}
+}
+
+// WithLoaderOption registers a Loader that calls fetch. Each MakeDocuments,
+// MakeDocumentsByNamespace or Process call gets its own Loader, available to
+// the DocumentBuilder through LoaderFromContext, so values are only cached
+// during the call. A StreamProcessor shares a single Loader between the
+// messages it builds, which coalesces their concurrent Load calls, but values
+// are not cached once fetched, so the memory does not grow with the stream.
+func WithLoaderOption[K comparable, V any](
+	key *LoaderKey[K, V],
+	fetch BatchLoadFunc[K, V],
+	config LoaderConfig,
+) Option {
+	return func(p *parallelProcessor) {
+		if p.loaders == nil {
+			p.loaders = make(map[any]func(context.Context, bool) any)
+		}
+		p.loaders[key] = func(ctx context.Context, cacheValues bool) any {
+			return newLoader(ctx, key.name, fetch, config, cacheValues)
+		}
+	}
+}
+
+// LoaderFromContext returns the Loader of the given LoaderKey for the current
+// call. It returns false if the LoaderKey was not registered with
+// WithLoaderOption.
+func LoaderFromContext[K comparable, V any](ctx context.Context, key *LoaderKey[K, V]) (*Loader[K, V], bool) {
+	loaders, _ := ctx.Value(loadersContextKey{}).(map[any]any)
+	loader, ok := loaders[key].(*Loader[K, V])
+	return loader, ok
+}
+
+type loadersContextKey struct{}
+
+// withLoaders returns a context with a new Loader for each one registered in
+// the processor. If cacheValues is false, the loaders keep no value once it is
+// fetched.
+func (p *parallelProcessor) withLoaders(ctx context.Context, cacheValues bool) context.Context {
+	if len(p.loaders) == 0 {
+		return ctx
+	}
+
+	loaders := make(map[any]any, len(p.loaders))
+	for key, newLoader := range p.loaders {
+		loaders[key] = newLoader(ctx, cacheValues)
+	}
+	return context.WithValue(ctx, loadersContextKey{}, loaders)
+}
+
+// Loader coalesces the keys requested by concurrent Load calls into batched
+// BatchLoadFunc calls and caches their values. It is safe for concurrent use.
+type Loader[K comparable, V any] struct {
+	// ctx is the context of the call the Loader belongs to, which outlives
+	// the Load calls. Batches are fetched with it because they are shared by
+	// many Load calls, each one with its own context.
+	ctx         context.Context //nolint:containedctx // the Loader only lives during the call of ctx.
+	name        string
+	fetch       BatchLoadFunc[K, V]
+	config      LoaderConfig
+	cacheValues bool
+
+	mu      sync.Mutex
+	cache   map[K]*loaderResult[V]
+	pending *loaderBatch[K, V]
+}
+
+type loaderResult[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+type loaderBatch[K comparable, V any] struct {
+	keys    []K
+	results []*loaderResult[V]
+	timer   *time.Timer
+}
+
+func newLoader[K comparable, V any](
+	ctx context.Context,
+	name string,
+	fetch BatchLoadFunc[K, V],
+	config LoaderConfig,
+	cacheValues bool,
+) *Loader[K, V] {
+	if config.Wait <= 0 {
+		config.Wait = defaultLoaderWait
+	}
+	return &Loader[K, V]{
+		ctx:         ctx,
+		name:        name,
+		fetch:       fetch,
+		config:      config,
+		cacheValues: cacheValues,
+		cache:       make(map[K]*loaderResult[V]),
+	}
+}
+
+// Load returns the value of the key, waiting for the batch it was added to.
+// Values are cached, but keys whose batch failed are fetched again by the
+// next Load. In a StreamProcessor, only the keys being fetched are shared and
+// every other key is fetched again.
+func (l *Loader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	l.mu.Lock()
+	result := l.resultFor(key)
+	l.mu.Unlock()
+
+	return result.wait(ctx)
+}
+
+// LoadMany is like Load, but for many keys, which are added to the same
+// batches. The values are in the same order of the keys.
+func (l *Loader[K, V]) LoadMany(ctx context.Context, keys []K) ([]V, error) {
+	results := make([]*loaderResult[V], len(keys))
+	l.mu.Lock()
+	for i, key := range keys {
+		results[i] = l.resultFor(key)
+	}
+	l.mu.Unlock()
+
+	values := make([]V, len(keys))
+	for i, result := range results {
+		value, err := result.wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// resultFor returns the cached result of the key, enqueuing it if there is
+// none. It must be called with mu locked.
+func (l *Loader[K, V]) resultFor(key K) *loaderResult[V] {
+	result, ok := l.cache[key]
+	if !ok {
+		result = &loaderResult[V]{done: make(chan struct{})}
+		l.cache[key] = result
+		l.enqueue(key, result)
+	}
+	return result
+}
+
+func (r *loaderResult[V]) wait(ctx context.Context) (V, error) {
+	select {
+	case <-r.done:
+		return r.value, r.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// enqueue adds the key to the pending batch. It must be called with mu locked.
+func (l *Loader[K, V]) enqueue(key K, result *loaderResult[V]) {
+	batch := l.pending
+	if batch == nil {
+		batch = &loaderBatch[K, V]{}
+		batch.timer = time.AfterFunc(l.config.Wait, func() {
+			l.dispatch(batch)
+		})
+		l.pending = batch
+	}
+
+	batch.keys = append(batch.keys, key)
+	batch.results = append(batch.results, result)
+
+	if l.config.MaxBatchSize > 0 && len(batch.keys) >= l.config.MaxBatchSize {
+		batch.timer.Stop()
+		l.pending = nil
+		go l.fetchBatch(batch)
+	}
+}
+
+// dispatch fetches the batch if it is still pending.
+func (l *Loader[K, V]) dispatch(batch *loaderBatch[K, V]) {
+	l.mu.Lock()
+	if l.pending != batch {
+		l.mu.Unlock()
+		return
+	}
+	l.pending = nil
+	l.mu.Unlock()
+
+	l.fetchBatch(batch)
+}
+
+func (l *Loader[K, V]) fetchBatch(batch *loaderBatch[K, V]) {
+	values, err := recoverBuild(func(ctx context.Context) (map[K]V, error) {
+		return l.fetch(ctx, batch.keys)
+	})(l.ctx)
+	if err != nil {
+		err = errors.E(err, errors.KV("loader", l.name))
+	}
+
+	// Failed keys are not cached, so a retried Build fetches them again.
+	if err != nil || !l.cacheValues {
+		l.mu.Lock()
+		for i, key := range batch.keys {
+			if l.cache[key] == batch.results[i] {
+				delete(l.cache, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+
+	for i, key := range batch.keys {
+		result := batch.results[i]
+		switch value, ok := values[key]; {
+		case err != nil:
+			result.err = err
+		case !ok:
+			result.err = errors.E(ErrLoaderKeyNotFound, errors.KV("loader", l.name), errors.KV("key", key))
+		default:
+			result.value = value
+		}
+		close(result.done)
+	}
+}
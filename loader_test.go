@@ -0,0 +1,176 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MakeDocuments_Loader(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		config LoaderConfig
+	}{
+		{
+			name:   "default config",
+			config: LoaderConfig{},
+		},
+		{
+			name:   "max batch size",
+			config: LoaderConfig{MaxBatchSize: 2, Wait: 10 * time.Millisecond},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mu          sync.Mutex
+				fetchedKeys []int
+			)
+			namesKey := NewLoaderKey[int, string]("names")
+			fetch := func(_ context.Context, keys []int) (map[int]string, error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				if test.config.MaxBatchSize > 0 {
+					assert.LessOrEqual(t, len(keys), test.config.MaxBatchSize)
+				}
+				fetchedKeys = append(fetchedKeys, keys...)
+
+				names := make(map[int]string, len(keys))
+				for _, key := range keys {
+					names[key] = fmt.Sprintf("name-%d", key)
+				}
+				return names, nil
+			}
+
+			builder := DocumentBuilderFunc(func(ctx context.Context, msg Message) ([]Document, error) {
+				loader, ok := LoaderFromContext(ctx, namesKey)
+				if !assert.True(t, ok) {
+					return nil, nil
+				}
+
+				id, _ := strconv.Atoi(msg.(*mockMessage).id)
+				name, err := loader.Load(ctx, id%5)
+				if err != nil {
+					return nil, err
+				}
+				return []Document{mockDocument{id: name}}, nil
+			})
+
+			parallelProcessor := NewParallelProcessor(
+				map[MessageType]DocumentBuilder{"type-1": builder},
+				WithLoaderOption(namesKey, fetch, test.config),
+			)
+
+			msgs := make([]Message, 0, 20)
+			for i := range 20 {
+				msgs = append(msgs, &mockMessage{id: strconv.Itoa(i), namespace: "tiramisu", messageType: "type-1"})
+			}
+
+			for range 2 {
+				documents, err := parallelProcessor.MakeDocuments(context.Background(), msgs)
+				assert.NoError(t, err)
+				if assert.Len(t, documents, 20) {
+					assert.Equal(t, mockDocument{id: "name-3"}, documents[13])
+				}
+			}
+
+			// Each call has its own cache.
+			assert.ElementsMatch(t, []int{0, 1, 2, 3, 4, 0, 1, 2, 3, 4}, fetchedKeys)
+		})
+	}
+}
+
+func Test_StreamProcessor_Loader(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu          sync.Mutex
+		fetchedKeys []int
+	)
+	namesKey := NewLoaderKey[int, string]("names")
+	fetch := func(_ context.Context, keys []int) (map[int]string, error) {
+		mu.Lock()
+		fetchedKeys = append(fetchedKeys, keys...)
+		mu.Unlock()
+		return map[int]string{0: "name-0"}, nil
+	}
+
+	builder := DocumentBuilderFunc(func(ctx context.Context, msg Message) ([]Document, error) {
+		loader, ok := LoaderFromContext(ctx, namesKey)
+		if !assert.True(t, ok) {
+			return nil, nil
+		}
+
+		name, err := loader.Load(ctx, 0)
+		if err != nil {
+			return nil, err
+		}
+		return []Document{mockDocument{id: name + "-" + msg.(*mockMessage).id}}, nil
+	})
+
+	streamProcessor := NewStreamProcessor(
+		map[MessageType]DocumentBuilder{"type-1": builder},
+		WithMaxWorkersOption(2),
+		WithStreamBatchSizeOption(2),
+		WithLoaderOption(namesKey, fetch, LoaderConfig{Wait: 100 * time.Millisecond}),
+	)
+
+	// The first two messages are built concurrently and share the fetch. The
+	// last one is only sent after they were handled, so it fetches again.
+	batches := make(chan Batch, 2)
+	msgs := make(chan Message)
+	go func() {
+		defer close(msgs)
+		msgs <- &mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"}
+		msgs <- &mockMessage{id: "2", namespace: "tiramisu", messageType: "type-1"}
+		<-batches
+		msgs <- &mockMessage{id: "3", namespace: "tiramisu", messageType: "type-1"}
+	}()
+
+	err := streamProcessor.ProcessChannel(context.Background(), msgs, func(_ context.Context, batch Batch) error {
+		batches <- batch
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 0}, fetchedKeys)
+}
+
+func Test_Loader_Errors(t *testing.T) {
+	t.Parallel()
+
+	var calls int
+	loader := newLoader(context.Background(), "names", func(context.Context, []int) (map[int]string, error) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		return map[int]string{1: "one"}, nil
+	}, LoaderConfig{}, true)
+
+	_, err := loader.Load(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrBuildPanic)
+
+	names, err := loader.LoadMany(context.Background(), []int{1, 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"one", "one"}, names)
+
+	_, err = loader.LoadMany(context.Background(), []int{1, 2})
+	assert.ErrorIs(t, err, ErrLoaderKeyNotFound)
+	assert.Equal(t, 3, calls)
+
+	_, ok := LoaderFromContext(context.Background(), NewLoaderKey[int, string]("names"))
+	assert.False(t, ok)
+}
@@ -8,3 +8,7 @@ type Message interface {
 	GetType() MessageType
 	UpdateLogWithData(context.Context)
 }
+
+// MessageKeyFunc returns a key that identifies a Message, like the ID of the
+// entity it refers to.
+type MessageKeyFunc func(Message) string
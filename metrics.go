@@ -0,0 +1,49 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"time"
+)
+
+// MessageStatus is the outcome of building a Message, reported to Metrics.
+type MessageStatus string
+
+const (
+	// MessageStatusProcessed means the Message built documents or child
+	// messages.
+	MessageStatusProcessed MessageStatus = "processed"
+	// MessageStatusIgnored means the Message built nothing.
+	MessageStatusIgnored MessageStatus = "ignored"
+	// MessageStatusFailed means the Message failed to build.
+	MessageStatusFailed MessageStatus = "failed"
+)
+
+// Metrics receives measurements from the processor. Implementations must be
+// safe for concurrent use. See NewOTelMetrics for an OpenTelemetry
+// implementation.
+type Metrics interface {
+	// ObserveBatchSize is called with the number of messages given to each
+	// MakeDocuments, MakeDocumentsByNamespace or Process call.
+	ObserveBatchSize(ctx context.Context, size int)
+	// CountMessage is called once for each Message built, including child
+	// messages.
+	CountMessage(ctx context.Context, messageType MessageType, status MessageStatus)
+	// ObserveBuildDuration is called after each DocumentBuilder's Build call,
+	// including retries.
+	ObserveBuildDuration(ctx context.Context, messageType MessageType, duration time.Duration)
+	// CountDocuments is called with the number of documents produced for a
+	// Namespace, after deduplication.
+	CountDocuments(ctx context.Context, namespace Namespace, n int)
+	// CountDuplicatesRemoved is called with the number of documents of a
+	// Namespace removed by the DeduplicateDocumentsFunc and the DedupStore.
+	CountDuplicatesRemoved(ctx context.Context, namespace Namespace, n int)
+}
+
+// noopMetrics is the default Metrics, which discards every measurement.
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveBatchSize(context.Context, int)                            {}
+func (noopMetrics) CountMessage(context.Context, MessageType, MessageStatus)         {}
+func (noopMetrics) ObserveBuildDuration(context.Context, MessageType, time.Duration) {}
+func (noopMetrics) CountDocuments(context.Context, Namespace, int)                   {}
+func (noopMetrics) CountDuplicatesRemoved(context.Context, Namespace, int)           {}
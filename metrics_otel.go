@@ -0,0 +1,113 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+type otelMetrics struct {
+	batchSize         metric.Int64Histogram
+	messages          metric.Int64Counter
+	buildDuration     metric.Float64Histogram
+	documents         metric.Int64Counter
+	duplicatesRemoved metric.Int64Counter
+}
+
+// NewOTelMetrics returns a Metrics that records measurements with the given
+// OpenTelemetry Meter, using the following instruments:
+//
+//   - gomsgprocessor.batch.size: histogram of messages per call.
+//   - gomsgprocessor.messages: counter of messages by message_type and status.
+//   - gomsgprocessor.build.duration: histogram of Build calls, in seconds, by
+//     message_type.
+//   - gomsgprocessor.documents: counter of documents produced by namespace.
+//   - gomsgprocessor.duplicates_removed: counter of documents removed by
+//     deduplication by namespace.
+func NewOTelMetrics(meter metric.Meter) (Metrics, error) {
+	const op = errors.Op("gomsgprocessor.NewOTelMetrics")
+
+	var (
+		m   otelMetrics
+		err error
+	)
+
+	m.batchSize, err = meter.Int64Histogram(
+		"gomsgprocessor.batch.size",
+		metric.WithDescription("Number of messages given to each processor call."),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	m.messages, err = meter.Int64Counter(
+		"gomsgprocessor.messages",
+		metric.WithDescription("Number of messages built, by message type and status."),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	m.buildDuration, err = meter.Float64Histogram(
+		"gomsgprocessor.build.duration",
+		metric.WithDescription("Duration of each DocumentBuilder's Build call."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	m.documents, err = meter.Int64Counter(
+		"gomsgprocessor.documents",
+		metric.WithDescription("Number of documents produced, by namespace."),
+		metric.WithUnit("{document}"),
+	)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	m.duplicatesRemoved, err = meter.Int64Counter(
+		"gomsgprocessor.duplicates_removed",
+		metric.WithDescription("Number of documents removed by deduplication, by namespace."),
+		metric.WithUnit("{document}"),
+	)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	return &m, nil
+}
+
+func (m *otelMetrics) ObserveBatchSize(ctx context.Context, size int) {
+	m.batchSize.Record(ctx, int64(size))
+}
+
+func (m *otelMetrics) CountMessage(ctx context.Context, messageType MessageType, status MessageStatus) {
+	m.messages.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("message_type", string(messageType)),
+		attribute.String("status", string(status)),
+	))
+}
+
+func (m *otelMetrics) ObserveBuildDuration(ctx context.Context, messageType MessageType, duration time.Duration) {
+	m.buildDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(
+		attribute.String("message_type", string(messageType)),
+	))
+}
+
+func (m *otelMetrics) CountDocuments(ctx context.Context, namespace Namespace, n int) {
+	m.documents.Add(ctx, int64(n), metric.WithAttributes(
+		attribute.String("namespace", string(namespace)),
+	))
+}
+
+func (m *otelMetrics) CountDuplicatesRemoved(ctx context.Context, namespace Namespace, n int) {
+	m.duplicatesRemoved.Add(ctx, int64(n), metric.WithAttributes(
+		attribute.String("namespace", string(namespace)),
+	))
+}
@@ -0,0 +1,138 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+func Test_MakeDocuments_Metrics(t *testing.T) {
+	t.Parallel()
+
+	builder := new(mockDocumentBuilder)
+	builder.
+		On("Build", &mockMessage{id: "id-1", namespace: "tiramisu", messageType: "type-1"}).
+		Return([]Document{mockDocument{id: "doc-1"}, mockDocument{id: "doc-1"}}, nil).
+		Once()
+	builder.
+		On("Build", &mockMessage{id: "id-2", namespace: "tiramisu", messageType: "type-1"}).
+		Return(nil, nil).
+		Once()
+	builder.
+		On("Build", &mockMessage{id: "id-3", namespace: "tiramisu", messageType: "type-1"}).
+		Return(nil, errors.New("document builder error")).
+		Once()
+
+	metrics := newRecordingMetrics()
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": builder,
+		},
+		WithMetricsOption(metrics),
+		WithDeduplicateDocumentsOption(DeduplicateKeepFirst(func(d Document) (string, error) {
+			return d.(mockDocument).id, nil
+		})),
+	)
+
+	_, err := parallelProcessor.Process(
+		context.Background(),
+		[]Message{
+			&mockMessage{id: "id-1", namespace: "tiramisu", messageType: "type-1"},
+			&mockMessage{id: "id-2", namespace: "tiramisu", messageType: "type-1"},
+			&mockMessage{id: "id-3", namespace: "tiramisu", messageType: "type-1"},
+		},
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3}, metrics.batchSizes)
+	assert.Equal(
+		t,
+		map[MessageStatus]int{
+			MessageStatusProcessed: 1,
+			MessageStatusIgnored:   1,
+			MessageStatusFailed:    1,
+		},
+		metrics.messages["type-1"],
+	)
+	assert.Equal(t, 3, metrics.builds["type-1"])
+	assert.Equal(t, 1, metrics.documents["tiramisu"])
+	assert.Equal(t, 1, metrics.duplicatesRemoved["tiramisu"])
+	builder.AssertExpectations(t)
+}
+
+func Test_NewOTelMetrics(t *testing.T) {
+	t.Parallel()
+
+	metrics, err := NewOTelMetrics(noop.NewMeterProvider().Meter("gomsgprocessor"))
+	assert.NoError(t, err)
+
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": newConcurrencyTrackingBuilder(),
+		},
+		WithMetricsOption(metrics),
+	)
+
+	documents, err := parallelProcessor.MakeDocuments(
+		context.Background(),
+		makeMockMessages(2, "tiramisu", "type-1"),
+	)
+	assert.NoError(t, err)
+	assert.Len(t, documents, 2)
+}
+
+type recordingMetrics struct {
+	mu                sync.Mutex
+	batchSizes        []int
+	messages          map[MessageType]map[MessageStatus]int
+	builds            map[MessageType]int
+	documents         map[Namespace]int
+	duplicatesRemoved map[Namespace]int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		messages:          make(map[MessageType]map[MessageStatus]int),
+		builds:            make(map[MessageType]int),
+		documents:         make(map[Namespace]int),
+		duplicatesRemoved: make(map[Namespace]int),
+	}
+}
+
+func (m *recordingMetrics) ObserveBatchSize(_ context.Context, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batchSizes = append(m.batchSizes, size)
+}
+
+func (m *recordingMetrics) CountMessage(_ context.Context, messageType MessageType, status MessageStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.messages[messageType] == nil {
+		m.messages[messageType] = make(map[MessageStatus]int)
+	}
+	m.messages[messageType][status]++
+}
+
+func (m *recordingMetrics) ObserveBuildDuration(_ context.Context, messageType MessageType, _ time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.builds[messageType]++
+}
+
+func (m *recordingMetrics) CountDocuments(_ context.Context, namespace Namespace, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.documents[namespace] += n
+}
+
+func (m *recordingMetrics) CountDuplicatesRemoved(_ context.Context, namespace Namespace, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.duplicatesRemoved[namespace] += n
+}
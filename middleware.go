@@ -0,0 +1,276 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// DocumentBuilderFunc is an adapter to use an ordinary function as a
+// DocumentBuilder.
+type DocumentBuilderFunc func(context.Context, Message) ([]Document, error)
+
+// Build calls f(ctx, msg).
+func (f DocumentBuilderFunc) Build(ctx context.Context, msg Message) ([]Document, error) {
+	return f(ctx, msg)
+}
+
+// BuilderMiddleware wraps a DocumentBuilder to add behavior around its Build,
+// like logging or validation.
+//
+// The DocumentBuilder returned by a middleware must implement
+// ExpandingDocumentBuilder to keep emitting child messages, and
+// BatchDocumentBuilder to keep building in batches. The stock middlewares
+// (LoggingMiddleware, ValidateMessageMiddleware and
+// ValidateDocumentsMiddleware) implement them when the wrapped builder does.
+type BuilderMiddleware func(DocumentBuilder) DocumentBuilder
+
+// chainBuilderMiddlewares wraps the builder with the middlewares, the first
+// one being the outermost.
+func chainBuilderMiddlewares(builder DocumentBuilder, middlewares ...BuilderMiddleware) DocumentBuilder {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		builder = middlewares[i](builder)
+	}
+	return builder
+}
+
+// applyBuilderMiddlewares replaces the processor's builders by a copy wrapped
+// with the global and the MessageType middlewares. Default builders for unknown
+// message types are wrapped with the global middlewares.
+func (p *parallelProcessor) applyBuilderMiddlewares() {
+	if len(p.middlewares) == 0 && len(p.middlewaresByType) == 0 {
+		return
+	}
+
+	builders := make(map[MessageType]DocumentBuilder, len(p.builders))
+	for messageType, builder := range p.builders {
+		builder = chainBuilderMiddlewares(builder, p.middlewaresByType[messageType]...)
+		builders[messageType] = chainBuilderMiddlewares(builder, p.middlewares...)
+	}
+	p.builders = builders
+
+	if p.unknownMessageTypeFallback.builder != nil {
+		p.unknownMessageTypeFallback.builder = chainBuilderMiddlewares(
+			p.unknownMessageTypeFallback.builder,
+			p.middlewares...,
+		)
+	}
+	for namespace, fallback := range p.unknownMessageTypeFallbackByNamespace {
+		if fallback.builder != nil {
+			fallback.builder = chainBuilderMiddlewares(fallback.builder, p.middlewares...)
+			p.unknownMessageTypeFallbackByNamespace[namespace] = fallback
+		}
+	}
+}
+
+// buildBatchFunc builds many messages at once, returning one BatchBuildResult
+// for each Message, like BatchDocumentBuilder's BuildBatch.
+type buildBatchFunc func(context.Context, []Message) ([]BatchBuildResult, error)
+
+// aroundBuildFunc runs around every build of a DocumentBuilder wrapped by
+// aroundBuilder. A single Message build is seen as a batch of one.
+type aroundBuildFunc func(context.Context, []Message, buildBatchFunc) ([]BatchBuildResult, error)
+
+// aroundBuilder wraps next so around is called for each of its builds. The
+// returned DocumentBuilder implements ExpandingDocumentBuilder and
+// BatchDocumentBuilder if next does, so the stock middlewares keep the
+// behaviours of the builders they wrap.
+func aroundBuilder(next DocumentBuilder, around aroundBuildFunc) DocumentBuilder {
+	builder := aroundDocumentBuilder{next: next, around: around}
+
+	expandingBuilder, expanding := next.(ExpandingDocumentBuilder)
+	batchBuilder, batch := next.(BatchDocumentBuilder)
+	switch {
+	case expanding && batch:
+		return aroundExpandingBatchDocumentBuilder{
+			aroundExpandingDocumentBuilder{builder, expandingBuilder},
+			batchBuilder,
+		}
+	case expanding:
+		return aroundExpandingDocumentBuilder{builder, expandingBuilder}
+	case batch:
+		return aroundBatchDocumentBuilder{builder, batchBuilder}
+	default:
+		return builder
+	}
+}
+
+type aroundDocumentBuilder struct {
+	next   DocumentBuilder
+	around aroundBuildFunc
+}
+
+func (b aroundDocumentBuilder) Build(ctx context.Context, msg Message) ([]Document, error) {
+	documents, _, err := b.buildOne(ctx, msg, func(ctx context.Context, msg Message) ([]Document, []Message, error) {
+		documents, err := b.next.Build(ctx, msg)
+		return documents, nil, err
+	})
+	return documents, err
+}
+
+// buildOne runs around for a batch of one Message built by build.
+func (b aroundDocumentBuilder) buildOne(
+	ctx context.Context,
+	msg Message,
+	build func(context.Context, Message) ([]Document, []Message, error),
+) ([]Document, []Message, error) {
+	msgs := []Message{msg}
+	results, err := b.around(ctx, msgs, func(ctx context.Context, msgs []Message) ([]BatchBuildResult, error) {
+		documents, children, err := build(ctx, msgs[0])
+		return []BatchBuildResult{{Documents: documents, Children: children, Err: err}}, nil
+	})
+	if err == nil {
+		err = checkBatchResults(msgs, results)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return results[0].Documents, results[0].Children, results[0].Err
+}
+
+type aroundExpandingDocumentBuilder struct {
+	aroundDocumentBuilder
+	expandingBuilder ExpandingDocumentBuilder
+}
+
+func (b aroundExpandingDocumentBuilder) BuildWithChildren(
+	ctx context.Context,
+	msg Message,
+) ([]Document, []Message, error) {
+	return b.buildOne(ctx, msg, b.expandingBuilder.BuildWithChildren)
+}
+
+type aroundBatchDocumentBuilder struct {
+	aroundDocumentBuilder
+	batchBuilder BatchDocumentBuilder
+}
+
+func (b aroundBatchDocumentBuilder) BuildBatch(ctx context.Context, msgs []Message) ([]BatchBuildResult, error) {
+	return b.around(ctx, msgs, b.batchBuilder.BuildBatch)
+}
+
+type aroundExpandingBatchDocumentBuilder struct {
+	aroundExpandingDocumentBuilder
+	batchBuilder BatchDocumentBuilder
+}
+
+func (b aroundExpandingBatchDocumentBuilder) BuildBatch(
+	ctx context.Context,
+	msgs []Message,
+) ([]BatchBuildResult, error) {
+	return b.around(ctx, msgs, b.batchBuilder.BuildBatch)
+}
+
+// LoggingMiddleware returns a BuilderMiddleware that logs, at debug level,
+// the duration and the outcome of each Message built. Failures are logged at
+// warn level. Messages built in a batch are logged with the batch duration.
+func LoggingMiddleware() BuilderMiddleware {
+	return func(next DocumentBuilder) DocumentBuilder {
+		return aroundBuilder(next, func(
+			ctx context.Context,
+			msgs []Message,
+			build buildBatchFunc,
+		) ([]BatchBuildResult, error) {
+			start := time.Now()
+			results, err := build(ctx, msgs)
+			duration := time.Since(start)
+
+			logger := log.Ctx(ctx)
+			for i, msg := range msgs {
+				var result BatchBuildResult
+				if err != nil {
+					result.Err = err
+				} else if i < len(results) {
+					result = results[i]
+				}
+
+				event := logger.Debug()
+				if result.Err != nil {
+					event = logger.Warn().Err(result.Err)
+				}
+				event.
+					Str("msg_type", string(msg.GetType())).
+					Str("msg_namespace", string(msg.GetNamespace())).
+					Int("documents", len(result.Documents)).
+					Dur("duration", duration).
+					Msg("Message built...")
+			}
+
+			return results, err
+		})
+	}
+}
+
+// ValidateMessageMiddleware returns a BuilderMiddleware that calls validate
+// before building each Message. If validate fails, the Message is not built
+// and its error has ErrCodeInvalidMessage associated with.
+func ValidateMessageMiddleware(validate func(context.Context, Message) error) BuilderMiddleware {
+	return func(next DocumentBuilder) DocumentBuilder {
+		return aroundBuilder(next, func(
+			ctx context.Context,
+			msgs []Message,
+			build buildBatchFunc,
+		) ([]BatchBuildResult, error) {
+			results := make([]BatchBuildResult, len(msgs))
+			validMsgs := make([]Message, 0, len(msgs))
+			validIndexes := make([]int, 0, len(msgs))
+			for i, msg := range msgs {
+				if err := validate(ctx, msg); err != nil {
+					results[i].Err = errors.E(err, ErrCodeInvalidMessage)
+					continue
+				}
+				validMsgs = append(validMsgs, msg)
+				validIndexes = append(validIndexes, i)
+			}
+			if len(validMsgs) == 0 {
+				return results, nil
+			}
+
+			validResults, err := build(ctx, validMsgs)
+			if err == nil {
+				err = checkBatchResults(validMsgs, validResults)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			for i, result := range validResults {
+				results[validIndexes[i]] = result
+			}
+			return results, nil
+		})
+	}
+}
+
+// ValidateDocumentsMiddleware returns a BuilderMiddleware that calls validate
+// for each Document built. If validate fails, the Message fails and the error
+// has ErrCodeInvalidDocument associated with.
+func ValidateDocumentsMiddleware(validate func(Document) error) BuilderMiddleware {
+	return func(next DocumentBuilder) DocumentBuilder {
+		return aroundBuilder(next, func(
+			ctx context.Context,
+			msgs []Message,
+			build buildBatchFunc,
+		) ([]BatchBuildResult, error) {
+			results, err := build(ctx, msgs)
+			if err != nil {
+				return nil, err
+			}
+
+			for i := range results {
+				if results[i].Err != nil {
+					continue
+				}
+				for _, document := range results[i].Documents {
+					if err := validate(document); err != nil {
+						results[i] = BatchBuildResult{Err: errors.E(err, ErrCodeInvalidDocument)}
+						break
+					}
+				}
+			}
+			return results, nil
+		})
+	}
+}
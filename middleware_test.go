@@ -0,0 +1,164 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_BuilderMiddlewares(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var calls []string
+	recordingMiddleware := func(name string) BuilderMiddleware {
+		return func(next DocumentBuilder) DocumentBuilder {
+			return DocumentBuilderFunc(func(ctx context.Context, msg Message) ([]Document, error) {
+				mu.Lock()
+				calls = append(calls, name)
+				mu.Unlock()
+				return next.Build(ctx, msg)
+			})
+		}
+	}
+
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": newConcurrencyTrackingBuilder(),
+			"type-2": newConcurrencyTrackingBuilder(),
+		},
+		WithMessageTypeBuilderMiddlewaresOption("type-1", recordingMiddleware("type-1-a"), recordingMiddleware("type-1-b")),
+		WithBuilderMiddlewaresOption(recordingMiddleware("global-a"), recordingMiddleware("global-b")),
+	)
+
+	_, err := parallelProcessor.MakeDocuments(context.Background(), []Message{
+		&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"global-a", "global-b", "type-1-a", "type-1-b"}, calls)
+
+	calls = nil
+	_, err = parallelProcessor.MakeDocuments(context.Background(), []Message{
+		&mockMessage{id: "2", namespace: "tiramisu", messageType: "type-2"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"global-a", "global-b"}, calls)
+}
+
+func Test_ValidationMiddlewares(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+
+		middleware BuilderMiddleware
+
+		expectedErrCode errors.Code
+	}{
+		{
+			name: "valid",
+			middleware: ValidateMessageMiddleware(func(context.Context, Message) error {
+				return nil
+			}),
+		},
+		{
+			name: "invalid message",
+			middleware: ValidateMessageMiddleware(func(context.Context, Message) error {
+				return errors.New("missing id")
+			}),
+			expectedErrCode: ErrCodeInvalidMessage,
+		},
+		{
+			name: "invalid document",
+			middleware: ValidateDocumentsMiddleware(func(Document) error {
+				return errors.New("empty document")
+			}),
+			expectedErrCode: ErrCodeInvalidDocument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			parallelProcessor := NewParallelProcessor(
+				map[MessageType]DocumentBuilder{"type-1": newConcurrencyTrackingBuilder()},
+				WithBuilderMiddlewaresOption(LoggingMiddleware(), test.middleware),
+			)
+
+			result, err := parallelProcessor.Process(context.Background(), []Message{
+				&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+			})
+			assert.NoError(t, err)
+
+			if test.expectedErrCode == "" {
+				assert.Empty(t, result.Failures)
+				return
+			}
+			if assert.Len(t, result.Failures, 1) {
+				assert.Equal(t, test.expectedErrCode, result.Failures[0].Code)
+			}
+		})
+	}
+}
+
+func Test_StockMiddlewares_KeepBuilderInterfaces(t *testing.T) {
+	t.Parallel()
+
+	middlewares := []BuilderMiddleware{
+		LoggingMiddleware(),
+		ValidateMessageMiddleware(func(_ context.Context, msg Message) error {
+			if msg.(*mockMessage).id == "invalid" {
+				return errors.New("invalid id")
+			}
+			return nil
+		}),
+		ValidateDocumentsMiddleware(func(Document) error {
+			return nil
+		}),
+	}
+
+	t.Run("expanding builder", func(t *testing.T) {
+		t.Parallel()
+
+		parallelProcessor := NewParallelProcessor(
+			map[MessageType]DocumentBuilder{"type-1": countdownDocumentBuilder{}},
+			WithBuilderMiddlewaresOption(middlewares...),
+		)
+
+		documents, err := parallelProcessor.MakeDocuments(context.Background(), []Message{
+			&mockMessage{id: "2", namespace: "tiramisu", messageType: "type-1"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(
+			t,
+			[]Document{mockDocument{id: "2"}, mockDocument{id: "1"}, mockDocument{id: "0"}},
+			documents,
+		)
+	})
+
+	t.Run("batch builder", func(t *testing.T) {
+		t.Parallel()
+
+		builder := &batchingDocumentBuilder{}
+		parallelProcessor := NewParallelProcessor(
+			map[MessageType]DocumentBuilder{"type-1": builder},
+			WithBuilderMiddlewaresOption(middlewares...),
+		)
+
+		result, err := parallelProcessor.Process(context.Background(), []Message{
+			&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+			&mockMessage{id: "invalid", namespace: "tiramisu", messageType: "type-1"},
+			&mockMessage{id: "3", namespace: "tiramisu", messageType: "type-1"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []Document{mockDocument{id: "1"}, mockDocument{id: "3"}}, result.Documents)
+		if assert.Len(t, result.Failures, 1) {
+			assert.Equal(t, 1, result.Failures[0].Index)
+			assert.Equal(t, ErrCodeInvalidMessage, result.Failures[0].Code)
+		}
+		assert.Equal(t, []int{2}, builder.batchSizes)
+	})
+}
@@ -1,5 +1,11 @@
 package gomsgprocessor
 
+import (
+	"time"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
 // Option is used to configure the processor.
 type Option func(*parallelProcessor)
 
@@ -10,3 +16,332 @@ func WithDeduplicateDocumentsOption(d DeduplicateDocumentsFunc) Option {
 		p.deduplicateDocuments = d
 	}
 }
+
+// WithNamespaceDeduplicateDocumentsOption adds a DeduplicateDocumentsFunc used
+// only for the documents of the given Namespace. Namespaces without their own
+// function fall back to the one set by WithDeduplicateDocumentsOption.
+func WithNamespaceDeduplicateDocumentsOption(ns Namespace, d DeduplicateDocumentsFunc) Option {
+	return func(p *parallelProcessor) {
+		if p.deduplicateDocumentsByNamespace == nil {
+			p.deduplicateDocumentsByNamespace = make(map[Namespace]DeduplicateDocumentsFunc)
+		}
+		p.deduplicateDocumentsByNamespace[ns] = d
+	}
+}
+
+// WithMaxWorkersOption limits how many DocumentBuilder's Build calls run at the
+// same time. A value lower than 1 means no limit, which is the default.
+func WithMaxWorkersOption(n int) Option {
+	return func(p *parallelProcessor) {
+		p.concurrency.maxWorkers = n
+	}
+}
+
+// WithMessageTypeMaxWorkersOption limits how many Build calls run at the same
+// time for messages of the given MessageType. Messages waiting for this limit
+// do not hold worker slots, so other builders keep running.
+func WithMessageTypeMaxWorkersOption(t MessageType, n int) Option {
+	return func(p *parallelProcessor) {
+		if p.concurrency.byMessageType == nil {
+			p.concurrency.byMessageType = make(map[MessageType]int)
+		}
+		p.concurrency.byMessageType[t] = n
+	}
+}
+
+// WithNamespaceMaxWorkersOption limits how many Build calls run at the same
+// time for messages of the given Namespace. Messages waiting for this limit do
+// not hold worker slots, so other namespaces keep running.
+func WithNamespaceMaxWorkersOption(ns Namespace, n int) Option {
+	return func(p *parallelProcessor) {
+		if p.concurrency.byNamespace == nil {
+			p.concurrency.byNamespace = make(map[Namespace]int)
+		}
+		p.concurrency.byNamespace[ns] = n
+	}
+}
+
+// WithStreamBatchSizeOption sets how many documents of the same Namespace a
+// StreamProcessor accumulates before flushing them. A value lower than 1
+// disables this threshold, which is the default.
+func WithStreamBatchSizeOption(n int) Option {
+	return func(p *parallelProcessor) {
+		p.stream.batchSize = n
+	}
+}
+
+// WithStreamFlushIntervalOption sets how often a StreamProcessor flushes its
+// pending documents, regardless of the batch size. A value lower than 1
+// disables this threshold, which is the default.
+func WithStreamFlushIntervalOption(d time.Duration) Option {
+	return func(p *parallelProcessor) {
+		p.stream.flushInterval = d
+	}
+}
+
+// WithNamespaceOrderOption sets the order in which the documents of each
+// Namespace are returned. See NamespaceOrder for more information.
+func WithNamespaceOrderOption(order NamespaceOrder) Option {
+	return func(p *parallelProcessor) {
+		p.namespaceOrder = order
+	}
+}
+
+// WithRetryPolicyOption sets the RetryPolicy used when a DocumentBuilder's
+// Build fails. By default, builds are not retried.
+func WithRetryPolicyOption(policy RetryPolicy) Option {
+	return func(p *parallelProcessor) {
+		p.retryPolicy = policy
+	}
+}
+
+// WithMessageTypeRetryPolicyOption sets the RetryPolicy used only by the
+// DocumentBuilder of the given MessageType, overriding the one set by
+// WithRetryPolicyOption.
+func WithMessageTypeRetryPolicyOption(t MessageType, policy RetryPolicy) Option {
+	return func(p *parallelProcessor) {
+		if p.retryPolicyByType == nil {
+			p.retryPolicyByType = make(map[MessageType]RetryPolicy)
+		}
+		p.retryPolicyByType[t] = policy
+	}
+}
+
+// WithBuildTimeoutOption sets how long each DocumentBuilder's Build call may
+// take. The builder's context expires after it and, if Build fails because of
+// it, the error has ErrCodeBuildTimeout associated with. When a RetryPolicy is
+// set, each attempt has its own timeout. A BatchDocumentBuilder's BuildBatch
+// call has the timeout as a whole, not for each of its messages. A value lower
+// than 1 means no timeout, which is the default.
+func WithBuildTimeoutOption(d time.Duration) Option {
+	return func(p *parallelProcessor) {
+		p.buildTimeout = d
+	}
+}
+
+// WithMessageTypeBuildTimeoutOption sets the Build timeout only for the
+// DocumentBuilder of the given MessageType, overriding the one set by
+// WithBuildTimeoutOption.
+func WithMessageTypeBuildTimeoutOption(t MessageType, d time.Duration) Option {
+	return func(p *parallelProcessor) {
+		if p.buildTimeoutByType == nil {
+			p.buildTimeoutByType = make(map[MessageType]time.Duration)
+		}
+		p.buildTimeoutByType[t] = d
+	}
+}
+
+// WithMessageExpansionOption configures how child messages returned by an
+// ExpandingDocumentBuilder are built. Input messages have depth zero and a
+// child Message deeper than maxDepth fails with ErrMaxExpansionDepthExceeded.
+// If key is not nil, a child Message with the same key as one of the messages
+// it descends from fails with ErrMessageExpansionCycle. By default, maxDepth
+// is 10 and there is no cycle detection.
+func WithMessageExpansionOption(maxDepth int, key MessageKeyFunc) Option {
+	return func(p *parallelProcessor) {
+		p.expansion = expansionConfig{
+			maxDepth: maxDepth,
+			key:      key,
+		}
+	}
+}
+
+// WithMetricsOption sets the Metrics that receives measurements from the
+// processor. By default, measurements are discarded.
+func WithMetricsOption(m Metrics) Option {
+	return func(p *parallelProcessor) {
+		p.metrics = m
+	}
+}
+
+// WithBuildSpansOption creates a child span, tagged with the MessageType and
+// the Namespace, for each DocumentBuilder's Build call of a sampled Message.
+// sampleRate is the fraction, between 0 and 1, of messages sampled, so the
+// overhead stays low for huge batches. A BatchDocumentBuilder's chunks are
+// sampled instead, each one's BuildBatch call getting a span that also has the
+// number of messages. By default, no Message is sampled.
+func WithBuildSpansOption(sampleRate float64) Option {
+	return func(p *parallelProcessor) {
+		p.spans.buildSampleRate = sampleRate
+	}
+}
+
+// WithDeduplicateSpansOption creates a child span for the deduplication of
+// each Namespace.
+func WithDeduplicateSpansOption() Option {
+	return func(p *parallelProcessor) {
+		p.spans.deduplicate = true
+	}
+}
+
+// WithTracerProviderOption sets the OpenTelemetry TracerProvider used to create
+// the child spans of WithBuildSpansOption and WithDeduplicateSpansOption. By
+// default, the global TracerProvider is used.
+func WithTracerProviderOption(tp oteltrace.TracerProvider) Option {
+	return func(p *parallelProcessor) {
+		p.spans.tracerProvider = tp
+	}
+}
+
+// WithBuilderMiddlewaresOption wraps every DocumentBuilder with the given
+// middlewares, the first one being the outermost. Global middlewares wrap the
+// ones set by WithMessageTypeBuilderMiddlewaresOption.
+func WithBuilderMiddlewaresOption(middlewares ...BuilderMiddleware) Option {
+	return func(p *parallelProcessor) {
+		p.middlewares = append(p.middlewares, middlewares...)
+	}
+}
+
+// WithMessageTypeBuilderMiddlewaresOption wraps the DocumentBuilder of the
+// given MessageType with the given middlewares, the first one being the
+// outermost.
+func WithMessageTypeBuilderMiddlewaresOption(t MessageType, middlewares ...BuilderMiddleware) Option {
+	return func(p *parallelProcessor) {
+		if p.middlewaresByType == nil {
+			p.middlewaresByType = make(map[MessageType][]BuilderMiddleware)
+		}
+		p.middlewaresByType[t] = append(p.middlewaresByType[t], middlewares...)
+	}
+}
+
+// WithDeadLetterSinkOption sends the messages that fail to build, including
+// the ones whose MessageType has no DocumentBuilder, to the DeadLetterSink
+// instead of failing the batch. Dead-lettered messages build no documents and
+// are not reported in Process' Result. If the sink fails, the Message fails
+// with ErrCodeDeadLetter.
+func WithDeadLetterSinkOption(sink DeadLetterSink) Option {
+	return func(p *parallelProcessor) {
+		p.deadLetterSink = sink
+	}
+}
+
+// WithIgnoredMessageHookOption calls the hook for each Message that builds no
+// documents nor child messages, or whose DocumentBuilder returned
+// IgnoreMessage.
+func WithIgnoredMessageHookOption(hook IgnoredMessageHook) Option {
+	return func(p *parallelProcessor) {
+		p.ignoredMessageHook = hook
+	}
+}
+
+// WithDefaultDocumentBuilderOption builds the messages whose MessageType has no
+// DocumentBuilder with the given one. If namespaces are given, it only applies
+// to the messages of those namespaces.
+func WithDefaultDocumentBuilderOption(builder DocumentBuilder, namespaces ...Namespace) Option {
+	return func(p *parallelProcessor) {
+		p.setUnknownMessageTypeFallback(unknownMessageTypeFallback{builder: builder}, namespaces)
+	}
+}
+
+// WithSkipUnknownMessageTypesOption ignores the messages whose MessageType has
+// no DocumentBuilder instead of failing them. If namespaces are given, it only
+// applies to the messages of those namespaces.
+func WithSkipUnknownMessageTypesOption(namespaces ...Namespace) Option {
+	return func(p *parallelProcessor) {
+		p.setUnknownMessageTypeFallback(unknownMessageTypeFallback{skip: true}, namespaces)
+	}
+}
+
+// WithDeadLetterUnknownMessageTypesOption sends the messages whose MessageType
+// has no DocumentBuilder to the DeadLetterSink instead of failing them, like
+// WithDeadLetterSinkOption does for every failure. If namespaces are given, it
+// only applies to the messages of those namespaces.
+func WithDeadLetterUnknownMessageTypesOption(sink DeadLetterSink, namespaces ...Namespace) Option {
+	return func(p *parallelProcessor) {
+		p.setUnknownMessageTypeFallback(unknownMessageTypeFallback{deadLetterSink: sink}, namespaces)
+	}
+}
+
+// WithRouterOption adds routers used to pick the DocumentBuilder of the
+// messages whose MessageType is not in the builders map. Routers are tried in
+// the order they were given. See Router.
+func WithRouterOption(routers ...Router) Option {
+	return func(p *parallelProcessor) {
+		p.routers = append(p.routers, routers...)
+	}
+}
+
+// WithBuildBatchSizeOption limits the number of messages given to each
+// BatchDocumentBuilder's BuildBatch call. Values lower than 1, the default,
+// put all messages of the same MessageType and Namespace in a single call.
+func WithBuildBatchSizeOption(size int) Option {
+	return func(p *parallelProcessor) {
+		p.buildBatchSize = size
+	}
+}
+
+// WithCoalesceMessagesOption coalesces the messages with the same key before
+// building them, so only one Build call is made for each key. Messages with an
+// empty key are not coalesced. If namespaces are given, it only applies to the
+// messages of those namespaces, which are never coalesced with messages of
+// other namespaces.
+//
+// Use KeepLatestMessage, KeepFirstMessage or a custom MessageReduceFunc. The
+// number of coalesced messages is reported in Process' Result. Failures and
+// ignored messages report the index of the Message kept, or of the last one
+// merged if the MessageReduceFunc returns a new Message. It does not apply to
+// a StreamProcessor.
+func WithCoalesceMessagesOption(key MessageKeyFunc, reduce MessageReduceFunc, namespaces ...Namespace) Option {
+	return func(p *parallelProcessor) {
+		coalescer := messageCoalescer{key: key, reduce: reduce}
+		if len(namespaces) == 0 {
+			p.messageCoalescer = coalescer
+			return
+		}
+
+		if p.messageCoalescerByNamespace == nil {
+			p.messageCoalescerByNamespace = make(map[Namespace]messageCoalescer)
+		}
+		for _, namespace := range namespaces {
+			p.messageCoalescerByNamespace[namespace] = coalescer
+		}
+	}
+}
+
+// WithPartitionKeyOption builds the messages with the same partition key one
+// at a time, in input order, while messages of different keys still run in
+// parallel. Messages with an empty key have no ordering guarantee.
+//
+// The messages of a BatchDocumentBuilder chunk are built together, so a chunk
+// is split when another Message of one of its partitions comes between its
+// messages. In a StreamProcessor, a Message waiting for its partition is
+// queued like one waiting for a worker limit (see NewStreamProcessor).
+func WithPartitionKeyOption(key MessageKeyFunc) Option {
+	return func(p *parallelProcessor) {
+		p.partitionKey = key
+	}
+}
+
+// WithDedupStoreOption drops, after deduplicating the documents of a batch, the
+// documents whose key was already emitted in an earlier batch, as remembered
+// by the DedupStore. If namespaces are given, it only applies to the documents
+// of those namespaces.
+//
+// The keys of the documents are only added to the store once they are
+// written, so the documents of a failed write are emitted again when their
+// messages are redelivered: when Process's Result is committed (see
+// Result.Commit), when the documents of MakeDocumentsByNamespace are committed
+// (see ParallelProcessor's CommitDocumentsByNamespace), or when a
+// StreamProcessor's BatchHandler returns nil. MakeDocuments only drops the
+// documents already in the store.
+//
+// Until their documents are committed, calls of the ParallelProcessor running
+// at the same time may return the same Document. The flushes of a
+// StreamProcessor are serialized while they check and add keys, so a
+// BatchHandler must not call the StreamProcessor that emitted its Batch.
+func WithDedupStoreOption(store DedupStore, key DocumentKeyFunc, namespaces ...Namespace) Option {
+	return func(p *parallelProcessor) {
+		dedup := documentDedup{store: store, key: key}
+		if len(namespaces) == 0 {
+			p.documentDedup = dedup
+			return
+		}
+
+		if p.documentDedupByNamespace == nil {
+			p.documentDedupByNamespace = make(map[Namespace]documentDedup)
+		}
+		for _, namespace := range namespaces {
+			p.documentDedupByNamespace[namespace] = dedup
+		}
+	}
+}
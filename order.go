@@ -0,0 +1,15 @@
+package gomsgprocessor
+
+// NamespaceOrder defines the order in which the documents of each Namespace
+// are returned by the processor. Inside a Namespace, documents always follow
+// the order of the messages that built them, as long as the
+// DeduplicateDocumentsFunc keeps it.
+type NamespaceOrder int
+
+const (
+	// NamespaceOrderFirstSeen returns namespaces in the order they first
+	// appear in the given []Message. This is the default.
+	NamespaceOrderFirstSeen NamespaceOrder = iota
+	// NamespaceOrderSorted returns namespaces sorted by name.
+	NamespaceOrderSorted
+)
@@ -2,11 +2,13 @@ package gomsgprocessor
 
 import (
 	"context"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/arquivei/foundationkit/errors"
 	"github.com/arquivei/foundationkit/trace"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/sync/errgroup"
 )
 
 // ParallelProcessor is a interface that process in parallel a slice of Message.
@@ -18,6 +20,29 @@ type ParallelProcessor interface {
 	// If not nil, this error has a (foundationkit/errors).Code associated with and
 	// can be a ErrCodeBuildDocuments or a ErrCodeDeduplicateDocuments.
 	MakeDocuments(context.Context, []Message) ([]Document, error)
+
+	// MakeDocumentsByNamespace is like MakeDocuments, but returns the
+	// documents grouped by the Namespace of the messages that built them.
+	// Namespaces without documents are not present in the returned map.
+	MakeDocumentsByNamespace(context.Context, []Message) (map[Namespace][]Document, error)
+
+	// CommitDocumentsByNamespace adds the keys of the documents returned by
+	// MakeDocumentsByNamespace to the DedupStore of their Namespace, so the
+	// next calls drop them (see WithDedupStoreOption). It must be called once
+	// the documents are written. Namespaces without a DedupStore are skipped.
+	//
+	// The returned error has a ErrCodeDeduplicateDocuments associated with.
+	CommitDocumentsByNamespace(context.Context, map[Namespace][]Document) error
+
+	// Process creates in parallel a slice of Document for given []Message, like
+	// MakeDocuments, but a Message that fails to build does not abort the
+	// batch. Its error is reported in the Result's Failures and the documents
+	// of all other messages are still returned.
+	//
+	// The returned error is only not nil when the whole batch fails and, like
+	// in MakeDocuments, has a ErrCodeBuildDocuments or a
+	// ErrCodeDeduplicateDocuments associated with.
+	Process(context.Context, []Message) (Result, error)
 }
 
 // DocumentBuilder is a interface that transforms a Message into []Document.
@@ -27,8 +52,37 @@ type DocumentBuilder interface {
 }
 
 type parallelProcessor struct {
-	builders             map[MessageType]DocumentBuilder
-	deduplicateDocuments DeduplicateDocumentsFunc
+	builders                        map[MessageType]DocumentBuilder
+	deduplicateDocuments            DeduplicateDocumentsFunc
+	deduplicateDocumentsByNamespace map[Namespace]DeduplicateDocumentsFunc
+	concurrency                     concurrencyLimits
+	stream                          streamConfig
+	namespaceOrder                  NamespaceOrder
+	retryPolicy                     RetryPolicy
+	retryPolicyByType               map[MessageType]RetryPolicy
+	buildTimeout                    time.Duration
+	buildTimeoutByType              map[MessageType]time.Duration
+	expansion                       expansionConfig
+	metrics                         Metrics
+	spans                           spanConfig
+	middlewares                     []BuilderMiddleware
+	middlewaresByType               map[MessageType][]BuilderMiddleware
+	deadLetterSink                  DeadLetterSink
+	ignoredMessageHook              IgnoredMessageHook
+	routers                         []Router
+	buildBatchSize                  int
+	loaders                         map[any]func(context.Context, bool) any
+	messageCoalescer                messageCoalescer
+	messageCoalescerByNamespace     map[Namespace]messageCoalescer
+	partitionKey                    MessageKeyFunc
+	documentDedup                   documentDedup
+	documentDedupByNamespace        map[Namespace]documentDedup
+	// dedupStoreMu is held by a StreamProcessor from the DedupStore check
+	// until the keys of the emitted documents are added. See lockDedupStores.
+	dedupStoreMu sync.Mutex
+
+	unknownMessageTypeFallback            unknownMessageTypeFallback
+	unknownMessageTypeFallbackByNamespace map[Namespace]unknownMessageTypeFallback
 }
 
 // NewParallelProcessor returns a new ParallelProcessor with a map of
@@ -40,15 +94,28 @@ func NewParallelProcessor(
 	builders map[MessageType]DocumentBuilder,
 	opts ...Option,
 ) ParallelProcessor {
+	return newParallelProcessor(builders, opts...)
+}
+
+func newParallelProcessor(
+	builders map[MessageType]DocumentBuilder,
+	opts ...Option,
+) *parallelProcessor {
 	p := &parallelProcessor{
 		builders:             builders,
 		deduplicateDocuments: defaultDeduplicateDocumentsFunc,
+		expansion: expansionConfig{
+			maxDepth: defaultMaxExpansionDepth,
+		},
+		metrics: noopMetrics{},
 	}
 
 	for _, opt := range opts {
 		opt(p)
 	}
 
+	p.applyBuilderMiddlewares()
+
 	return p
 }
 
@@ -58,92 +125,463 @@ func (p *parallelProcessor) MakeDocuments(ctx context.Context, msgs []Message) (
 	ctx, span := trace.StartSpan(ctx, op.String())
 	defer span.End(nil)
 
+	p.metrics.ObserveBatchSize(ctx, len(msgs))
+	ctx = p.withLoaders(ctx, true)
+
 	documentsByNamespace, err := p.parallelBuildDocumentsByNamespace(ctx, msgs)
 	if err != nil {
 		return nil, errors.E(op, err, ErrCodeBuildDocuments)
 	}
 
-	deduplicatedDocuments, err := p.deduplicateDocumentsForEachNamespace(documentsByNamespace)
+	deduplicatedDocuments, _, err := p.deduplicateDocumentsForEachNamespace(ctx, documentsByNamespace)
 	if err != nil {
 		return nil, errors.E(op, err, ErrCodeDeduplicateDocuments)
 	}
 
-	return deduplicatedDocuments, nil
+	return p.flattenDocuments(deduplicatedDocuments), nil
 }
 
-func (p *parallelProcessor) parallelBuildDocumentsByNamespace(
+func (p *parallelProcessor) MakeDocumentsByNamespace(
 	ctx context.Context,
 	msgs []Message,
 ) (map[Namespace][]Document, error) {
-	const op = errors.Op("parallelBuildDocumentsByNamespace")
+	const op = errors.Op("gomsgprocessor.parallelProcessor.MakeDocumentsByNamespace")
+
+	ctx, span := trace.StartSpan(ctx, op.String())
+	defer span.End(nil)
+
+	p.metrics.ObserveBatchSize(ctx, len(msgs))
+	ctx = p.withLoaders(ctx, true)
+
+	documentsByNamespace, err := p.parallelBuildDocumentsByNamespace(ctx, msgs)
+	if err != nil {
+		return nil, errors.E(op, err, ErrCodeBuildDocuments)
+	}
 
-	type builtDocument struct {
-		documents []Document
-		namespace Namespace
+	deduplicatedDocuments, _, err := p.deduplicateDocumentsForEachNamespace(ctx, documentsByNamespace)
+	if err != nil {
+		return nil, errors.E(op, err, ErrCodeDeduplicateDocuments)
 	}
 
-	builtDocuments := make([]builtDocument, len(msgs))
+	return deduplicatedDocuments.documents, nil
+}
 
-	g, ctx := errgroup.WithContext(ctx)
-	for i, msg := range msgs {
-		g.Go(func() error {
-			documentBuilder, ok := p.builders[msg.GetType()]
-			if !ok {
-				msg.UpdateLogWithData(ctx)
-				return errors.E(ErrMsgTypeHasNoBuilder, errors.KV("type", msg.GetType()))
-			}
+func (p *parallelProcessor) Process(ctx context.Context, msgs []Message) (Result, error) {
+	const op = errors.Op("gomsgprocessor.parallelProcessor.Process")
 
-			documents, err := documentBuilder.Build(ctx, msg)
-			if err != nil {
-				msg.UpdateLogWithData(ctx)
-				return err
-			}
+	ctx, span := trace.StartSpan(ctx, op.String())
+	defer span.End(nil)
 
-			if documents == nil {
-				msg.UpdateLogWithData(ctx)
-				log.Ctx(ctx).Info().Msg("Message ignored...")
-				return nil
-			}
+	p.metrics.ObserveBatchSize(ctx, len(msgs))
+	ctx = p.withLoaders(ctx, true)
+
+	builtMessages, err := p.parallelBuildMessages(ctx, msgs, false)
+	if err != nil {
+		return Result{}, errors.E(op, err, ErrCodeBuildDocuments)
+	}
 
-			builtDocuments[i] = builtDocument{
-				documents: documents,
-				namespace: msg.GetNamespace(),
+	var (
+		failures  []MessageFailure
+		ignored   []IgnoredMessage
+		coalesced map[Namespace]int
+	)
+	for _, builtMsg := range builtMessages {
+		if builtMsg.coalesced > 0 {
+			if coalesced == nil {
+				coalesced = make(map[Namespace]int)
 			}
+			coalesced[builtMsg.message.GetNamespace()] += builtMsg.coalesced
+		}
+	}
+	walkBuiltMessages(builtMessages, func(builtMsg *builtMessage) {
+		switch {
+		case builtMsg.err != nil:
+			failures = append(failures, newMessageFailure(builtMsg.index, builtMsg.message, builtMsg.err))
+		case builtMsg.ignored:
+			ignored = append(ignored, IgnoredMessage{
+				Index:   builtMsg.index,
+				Message: builtMsg.message,
+				Reason:  builtMsg.ignoreReason,
+			})
+		}
+	})
 
-			return nil
-		})
+	deduplicatedDocuments, emitted, err := p.deduplicateDocumentsForEachNamespace(
+		ctx,
+		groupDocumentsByNamespace(builtMessages),
+	)
+	if err != nil {
+		return Result{}, errors.E(op, err, ErrCodeDeduplicateDocuments)
 	}
 
-	err := g.Wait()
+	return Result{
+		Documents: p.flattenDocuments(deduplicatedDocuments),
+		Failures:  failures,
+		Ignored:   ignored,
+		Coalesced: coalesced,
+		emitted:   emitted,
+	}, nil
+}
+
+// builtMessage is the outcome of building a single Message.
+type builtMessage struct {
+	message Message
+	// index is the position of the input Message this one descends from.
+	index int
+	// depth is zero for input messages and grows for each expansion.
+	depth int
+	// ancestors holds the keys of the messages this one descends from, used
+	// to detect expansion cycles.
+	ancestors []string
+	// coalesced is the number of input messages merged into this one.
+	coalesced int
+
+	documents []Document
+	namespace Namespace
+	children  []builtMessage
+	err       error
+
+	ignored      bool
+	ignoreReason string
+}
+
+func (p *parallelProcessor) parallelBuildDocumentsByNamespace(
+	ctx context.Context,
+	msgs []Message,
+) (namespacedDocuments, error) {
+	const op = errors.Op("parallelBuildDocumentsByNamespace")
+
+	builtMessages, err := p.parallelBuildMessages(ctx, msgs, true)
 	if err != nil {
-		return nil, errors.E(op, err)
+		return namespacedDocuments{}, errors.E(op, err)
 	}
 
-	documentsByNamespace := make(map[Namespace][]Document, len(builtDocuments))
-	for _, builtDoc := range builtDocuments {
-		if builtDoc.documents == nil || builtDoc.namespace == "" {
-			continue
+	return groupDocumentsByNamespace(builtMessages), nil
+}
+
+// parallelBuildMessages builds every Message and returns one builtMessage for
+// each of them, in the same order, after coalescing them (see
+// newBuiltMessages). Child messages returned by an
+// ExpandingDocumentBuilder are built afterwards, one depth at a time, and kept
+// in their parent's builtMessage.
+//
+// If failFast is true, the first failure cancels the remaining builds and is
+// returned, otherwise failures are kept in their builtMessage.
+func (p *parallelProcessor) parallelBuildMessages(
+	ctx context.Context,
+	msgs []Message,
+	failFast bool,
+) ([]builtMessage, error) {
+	builtMessages := p.newBuiltMessages(msgs)
+
+	level := make([]*builtMessage, len(builtMessages))
+	for i := range builtMessages {
+		level[i] = &builtMessages[i]
+	}
+
+	for len(level) > 0 {
+		err := p.parallelBuildLevel(ctx, level, failFast)
+		if err != nil {
+			return nil, err
 		}
-		documentsByNamespace[builtDoc.namespace] = append(
-			documentsByNamespace[builtDoc.namespace],
-			builtDoc.documents...,
-		)
+		level = childrenOf(level)
+	}
+
+	return builtMessages, nil
+}
+
+func (p *parallelProcessor) parallelBuildLevel(
+	ctx context.Context,
+	level []*builtMessage,
+	failFast bool,
+) error {
+	units := p.buildUnits(level)
+
+	tasks := make([]task, len(units))
+	for i, unit := range units {
+		msgs := make([]Message, len(unit.builtMessages))
+		for j, builtMsg := range unit.builtMessages {
+			msgs[j] = builtMsg.message
+		}
+
+		tasks[i] = task{
+			index:         i,
+			messageType:   msgs[0].GetType(),
+			namespace:     msgs[0].GetNamespace(),
+			partitionKeys: p.partitionKeys(msgs...),
+		}
+	}
+
+	return newScheduler(p.concurrency).run(ctx, tasks, func(ctx context.Context, t task) error {
+		var err error
+		if unit := units[t.index]; unit.batchBuilder != nil {
+			err = p.buildBatch(ctx, unit.batchBuilder, unit.builtMessages)
+		} else {
+			err = p.buildMessage(ctx, unit.builtMessages[0])
+		}
+		if err != nil && failFast {
+			return err
+		}
+		return nil
+	})
+}
+
+// buildMessage builds the Message of the given builtMessage, filling it with
+// the outcome.
+func (p *parallelProcessor) buildMessage(ctx context.Context, builtMsg *builtMessage) error {
+	err := p.checkExpansion(builtMsg)
+	if err != nil {
+		builtMsg.message.UpdateLogWithData(ctx)
+		return p.failMessage(ctx, builtMsg, err)
+	}
+
+	output, err := p.buildDocuments(ctx, builtMsg.message)
+	return p.finishMessage(ctx, builtMsg, output, err)
+}
+
+// finishMessage fills the given builtMessage with the outcome of its build.
+func (p *parallelProcessor) finishMessage(
+	ctx context.Context,
+	builtMsg *builtMessage,
+	output buildOutput,
+	err error,
+) error {
+	if err != nil {
+		return p.failMessage(ctx, builtMsg, err)
+	}
+
+	if output.ignored {
+		p.ignoreMessage(ctx, builtMsg, output.ignoreReason)
+		return nil
+	}
+	p.metrics.CountMessage(ctx, builtMsg.message.GetType(), MessageStatusProcessed)
+
+	builtMsg.documents = output.documents
+	builtMsg.namespace = builtMsg.message.GetNamespace()
+	builtMsg.children = p.newChildren(builtMsg, output.children)
+	return nil
+}
+
+// failMessage records the failure of the given builtMessage. It returns nil
+// if the failure was handled by the DeadLetterSink.
+func (p *parallelProcessor) failMessage(ctx context.Context, builtMsg *builtMessage, err error) error {
+	p.metrics.CountMessage(ctx, builtMsg.message.GetType(), MessageStatusFailed)
+
+	err = p.deadLetter(ctx, builtMsg, err)
+	builtMsg.err = err
+	return err
+}
+
+// buildOutput is what a DocumentBuilder returns for a single Message.
+type buildOutput struct {
+	documents []Document
+	children  []Message
+
+	ignored      bool
+	ignoreReason string
+}
+
+// buildFunc is a single attempt of building a Message.
+type buildFunc func(context.Context) (buildOutput, error)
+
+// newBuildOutput returns the buildOutput of a Build call. A Message without
+// documents nor children, or whose error was returned by IgnoreMessage, is
+// ignored.
+func newBuildOutput(documents []Document, children []Message, err error) (buildOutput, error) {
+	if reason, ok := ignoreReason(err); ok {
+		return buildOutput{ignored: true, ignoreReason: reason}, nil
+	}
+	if err != nil {
+		return buildOutput{}, err
 	}
-	return documentsByNamespace, nil
+	return buildOutput{
+		documents: documents,
+		children:  children,
+		ignored:   documents == nil && children == nil,
+	}, nil
 }
 
+func (p *parallelProcessor) buildDocuments(ctx context.Context, msg Message) (buildOutput, error) {
+	documentBuilder, ok := p.builderFor(msg)
+	if !ok {
+		fallback := p.unknownMessageTypeFallbackFor(msg.GetNamespace())
+		switch {
+		case fallback.builder != nil:
+			documentBuilder = fallback.builder
+		case fallback.skip:
+			msg.UpdateLogWithData(ctx)
+			log.Ctx(ctx).Info().Msg("Message ignored, message type has no document builder...")
+			return buildOutput{ignored: true, ignoreReason: ErrMsgTypeHasNoBuilder.Error()}, nil
+		default:
+			msg.UpdateLogWithData(ctx)
+			return buildOutput{}, errors.E(
+				ErrMsgTypeHasNoBuilder,
+				ErrCodeMsgTypeHasNoBuilder,
+				errors.KV("type", msg.GetType()),
+			)
+		}
+	}
+
+	build := func(ctx context.Context) (buildOutput, error) {
+		defer func(start time.Time) {
+			p.metrics.ObserveBuildDuration(ctx, msg.GetType(), time.Since(start))
+		}(time.Now())
+
+		if expandingBuilder, ok := documentBuilder.(ExpandingDocumentBuilder); ok {
+			documents, children, err := expandingBuilder.BuildWithChildren(ctx, msg)
+			return newBuildOutput(documents, children, err)
+		}
+		documents, err := documentBuilder.Build(ctx, msg)
+		return newBuildOutput(documents, nil, err)
+	}
+
+	build = recoverBuild(build)
+	if p.spans.sampleBuild() {
+		build = traceBuild(p.spans.tracer(), msg, build)
+	}
+
+	timeout := p.buildTimeoutFor(msg.GetType())
+	output, err := retryBuild(ctx, p.retryPolicyFor(msg.GetType()), func(ctx context.Context) (buildOutput, error) {
+		return withBuildTimeout(ctx, timeout, build)
+	})
+	return logBuildOutput(ctx, msg, output, err)
+}
+
+// logBuildOutput logs the Message if it failed or was ignored.
+func logBuildOutput(ctx context.Context, msg Message, output buildOutput, err error) (buildOutput, error) {
+	if err != nil {
+		msg.UpdateLogWithData(ctx)
+		return buildOutput{}, err
+	}
+
+	if output.ignored {
+		msg.UpdateLogWithData(ctx)
+		log.Ctx(ctx).Info().Str("reason", output.ignoreReason).Msg("Message ignored...")
+	}
+
+	return output, nil
+}
+
+// namespacedDocuments holds documents grouped by Namespace, in input order,
+// and the namespaces in the order they were first seen.
+type namespacedDocuments struct {
+	namespaces []Namespace
+	documents  map[Namespace][]Document
+}
+
+func groupDocumentsByNamespace(builtMessages []builtMessage) namespacedDocuments {
+	grouped := namespacedDocuments{
+		documents: make(map[Namespace][]Document, len(builtMessages)),
+	}
+	walkBuiltMessages(builtMessages, func(builtMsg *builtMessage) {
+		if len(builtMsg.documents) == 0 || builtMsg.namespace == "" {
+			return
+		}
+		if _, ok := grouped.documents[builtMsg.namespace]; !ok {
+			grouped.namespaces = append(grouped.namespaces, builtMsg.namespace)
+		}
+		grouped.documents[builtMsg.namespace] = append(
+			grouped.documents[builtMsg.namespace],
+			builtMsg.documents...,
+		)
+	})
+	return grouped
+}
+
+// deduplicateDocumentsForEachNamespace deduplicates the documents of each
+// Namespace. It returns the keys to add to the DedupStore once the documents
+// are written.
 func (p *parallelProcessor) deduplicateDocumentsForEachNamespace(
-	documentsByNamespace map[Namespace][]Document,
-) ([]Document, error) {
+	ctx context.Context,
+	documentsByNamespace namespacedDocuments,
+) (namespacedDocuments, []emittedKeys, error) {
 	const op = errors.Op("deduplicateDocumentsForEachNamespace")
 
-	documents := make([]Document, 0, len(documentsByNamespace))
-	for _, docs := range documentsByNamespace {
-		deduplicatedDocuments, err := p.deduplicateDocuments(docs)
+	deduplicated := namespacedDocuments{
+		namespaces: make([]Namespace, 0, len(documentsByNamespace.namespaces)),
+		documents:  make(map[Namespace][]Document, len(documentsByNamespace.namespaces)),
+	}
+
+	var emitted []emittedKeys
+	for _, namespace := range documentsByNamespace.namespaces {
+		deduplicatedDocuments, keys, err := p.deduplicateNamespace(
+			ctx,
+			namespace,
+			documentsByNamespace.documents[namespace],
+		)
 		if err != nil {
-			return nil, errors.E(op, err)
+			return namespacedDocuments{}, nil, errors.E(op, err)
+		}
+		if keys.store != nil {
+			emitted = append(emitted, keys)
 		}
-		documents = append(documents, deduplicatedDocuments...)
+
+		// Namespaces left without documents are dropped, so they are not
+		// present in MakeDocumentsByNamespace's map.
+		if len(deduplicatedDocuments) == 0 {
+			continue
+		}
+		deduplicated.namespaces = append(deduplicated.namespaces, namespace)
+		deduplicated.documents[namespace] = deduplicatedDocuments
+	}
+	return deduplicated, emitted, nil
+}
+
+// deduplicateNamespace deduplicates the documents of a Namespace, drops the
+// ones already emitted according to the DedupStore and reports the outcome to
+// the processor's Metrics. It returns the keys to add to the DedupStore once
+// the documents are written.
+func (p *parallelProcessor) deduplicateNamespace(
+	ctx context.Context,
+	namespace Namespace,
+	documents []Document,
+) ([]Document, emittedKeys, error) {
+	var (
+		deduplicate           = p.deduplicateDocumentsFuncFor(namespace)
+		deduplicatedDocuments []Document
+		err                   error
+	)
+	if p.spans.deduplicate {
+		deduplicatedDocuments, err = traceDeduplicate(ctx, p.spans.tracer(), namespace, documents, deduplicate)
+	} else {
+		deduplicatedDocuments, err = deduplicate(documents)
+	}
+	if err != nil {
+		return nil, emittedKeys{}, err
+	}
+
+	deduplicatedDocuments, emitted, err := p.dropEmittedDocuments(ctx, namespace, deduplicatedDocuments)
+	if err != nil {
+		return nil, emittedKeys{}, err
+	}
+
+	p.metrics.CountDocuments(ctx, namespace, len(deduplicatedDocuments))
+	if removed := len(documents) - len(deduplicatedDocuments); removed > 0 {
+		p.metrics.CountDuplicatesRemoved(ctx, namespace, removed)
+	}
+	return deduplicatedDocuments, emitted, nil
+}
+
+// deduplicateDocumentsFuncFor returns the DeduplicateDocumentsFunc registered
+// for the Namespace, falling back to the processor's default one.
+func (p *parallelProcessor) deduplicateDocumentsFuncFor(namespace Namespace) DeduplicateDocumentsFunc {
+	if deduplicate, ok := p.deduplicateDocumentsByNamespace[namespace]; ok {
+		return deduplicate
+	}
+	return p.deduplicateDocuments
+}
+
+// flattenDocuments returns the documents of all namespaces in a single slice,
+// following the processor's NamespaceOrder.
+func (p *parallelProcessor) flattenDocuments(documentsByNamespace namespacedDocuments) []Document {
+	namespaces := documentsByNamespace.namespaces
+	if p.namespaceOrder == NamespaceOrderSorted {
+		namespaces = slices.Sorted(slices.Values(namespaces))
+	}
+
+	documents := make([]Document, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		documents = append(documents, documentsByNamespace.documents[namespace]...)
 	}
-	return documents, nil
+	return documents
 }
@@ -2,6 +2,7 @@ package gomsgprocessor
 
 import (
 	"context"
+	"slices"
 	"sort"
 	"strings"
 	"testing"
@@ -693,6 +694,205 @@ func Test_MakeDocuments(t *testing.T) {
 	}
 }
 
+func Test_Process(t *testing.T) {
+	t.Parallel()
+
+	failingMessage := &mockMessage{
+		id:          "id-2",
+		namespace:   "tiramisu",
+		messageType: "type-1",
+	}
+	unknownMessage := &mockMessage{
+		id:          "id-3",
+		namespace:   "tiramisu",
+		messageType: "type-2",
+	}
+	buildErr := errors.E(errors.New("document builder error"), errors.Code("BUILDER_CODE"))
+
+	builder := new(mockDocumentBuilder)
+	builder.
+		On(
+			"Build",
+			&mockMessage{
+				id:          "id-1",
+				namespace:   "tiramisu",
+				messageType: "type-1",
+			},
+		).
+		Return(
+			[]Document{
+				mockDocument{id: "doc-1"},
+			},
+			nil,
+		).
+		Once()
+	builder.
+		On("Build", failingMessage).
+		Return(nil, buildErr).
+		Once()
+
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{
+			MessageType("type-1"): builder,
+		},
+	)
+
+	result, err := parallelProcessor.Process(
+		context.Background(),
+		[]Message{
+			&mockMessage{
+				id:          "id-1",
+				namespace:   "tiramisu",
+				messageType: "type-1",
+			},
+			failingMessage,
+			unknownMessage,
+		},
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Document{mockDocument{id: "doc-1"}}, result.Documents)
+	if assert.Len(t, result.Failures, 2) {
+		assert.Equal(t, 1, result.Failures[0].Index)
+		assert.Equal(t, failingMessage, result.Failures[0].Message)
+		assert.Equal(t, buildErr, result.Failures[0].Err)
+		assert.Equal(t, errors.Code("BUILDER_CODE"), result.Failures[0].Code)
+
+		assert.Equal(t, 2, result.Failures[1].Index)
+		assert.Equal(t, unknownMessage, result.Failures[1].Message)
+		assert.EqualError(t, result.Failures[1].Err, "message type has no document builder [type=type-2]")
+		assert.Equal(t, ErrCodeMsgTypeHasNoBuilder, result.Failures[1].Code)
+	}
+	builder.AssertExpectations(t)
+}
+
+func Test_MakeDocuments_Order(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+
+		opts []Option
+
+		expectedResponse []Document
+	}{
+		{
+			name: "namespaces in first seen order",
+			expectedResponse: []Document{
+				mockDocument{id: "tiramisu-type-1-0"},
+				mockDocument{id: "tiramisu-type-1-1"},
+				mockDocument{id: "tiramisu-type-1-2"},
+				mockDocument{id: "potato-type-1-0"},
+				mockDocument{id: "potato-type-1-1"},
+			},
+		},
+		{
+			name: "namespaces sorted",
+			opts: []Option{WithNamespaceOrderOption(NamespaceOrderSorted)},
+			expectedResponse: []Document{
+				mockDocument{id: "potato-type-1-0"},
+				mockDocument{id: "potato-type-1-1"},
+				mockDocument{id: "tiramisu-type-1-0"},
+				mockDocument{id: "tiramisu-type-1-1"},
+				mockDocument{id: "tiramisu-type-1-2"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			parallelProcessor := NewParallelProcessor(
+				map[MessageType]DocumentBuilder{
+					"type-1": newConcurrencyTrackingBuilder(),
+				},
+				test.opts...,
+			)
+
+			documents, err := parallelProcessor.MakeDocuments(
+				context.Background(),
+				append(
+					makeMockMessages(3, "tiramisu", "type-1"),
+					makeMockMessages(2, "potato", "type-1")...,
+				),
+			)
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedResponse, documents)
+		})
+	}
+}
+
+func Test_MakeDocumentsByNamespace(t *testing.T) {
+	t.Parallel()
+
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": newConcurrencyTrackingBuilder(),
+		},
+		WithDeduplicateDocumentsOption(func(docs []Document) ([]Document, error) {
+			return docs[:1], nil
+		}),
+		WithNamespaceDeduplicateDocumentsOption("potato", func(docs []Document) ([]Document, error) {
+			return docs[1:], nil
+		}),
+	)
+
+	documentsByNamespace, err := parallelProcessor.MakeDocumentsByNamespace(
+		context.Background(),
+		append(
+			makeMockMessages(3, "tiramisu", "type-1"),
+			makeMockMessages(2, "potato", "type-1")...,
+		),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		map[Namespace][]Document{
+			"tiramisu": {mockDocument{id: "tiramisu-type-1-0"}},
+			"potato":   {mockDocument{id: "potato-type-1-1"}},
+		},
+		documentsByNamespace,
+	)
+}
+
+func Test_MakeDocumentsByNamespace_EmptyNamespaces(t *testing.T) {
+	t.Parallel()
+
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": newConcurrencyTrackingBuilder(),
+			"type-2": DocumentBuilderFunc(func(context.Context, Message) ([]Document, error) {
+				return []Document{}, nil
+			}),
+		},
+		WithNamespaceDeduplicateDocumentsOption("potato", func([]Document) ([]Document, error) {
+			return nil, nil
+		}),
+	)
+
+	documentsByNamespace, err := parallelProcessor.MakeDocumentsByNamespace(
+		context.Background(),
+		slices.Concat(
+			makeMockMessages(1, "tiramisu", "type-1"),
+			makeMockMessages(1, "potato", "type-1"),
+			makeMockMessages(1, "pudding", "type-2"),
+		),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		map[Namespace][]Document{
+			"tiramisu": {mockDocument{id: "tiramisu-type-1-0"}},
+		},
+		documentsByNamespace,
+	)
+	assert.NotContains(t, documentsByNamespace, Namespace("potato"))
+	assert.NotContains(t, documentsByNamespace, Namespace("pudding"))
+}
+
 func sortByID(docs []Document) []Document {
 	mockDocuments := make([]mockDocument, 0, len(docs))
 	for _, doc := range docs {
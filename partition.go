@@ -0,0 +1,20 @@
+package gomsgprocessor
+
+import "slices"
+
+// partitionKeys returns the distinct, non-empty partition keys of the
+// messages. See WithPartitionKeyOption.
+func (p *parallelProcessor) partitionKeys(msgs ...Message) []string {
+	if p.partitionKey == nil {
+		return nil
+	}
+
+	var keys []string
+	for _, msg := range msgs {
+		key := p.partitionKey(msg)
+		if key != "" && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,176 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PartitionKey(t *testing.T) {
+	t.Parallel()
+
+	var msgs []Message
+	for i := range 20 {
+		msgs = append(msgs, &mockMessage{
+			id:          fmt.Sprintf("key-%d/%02d", i%4, i),
+			namespace:   "tiramisu",
+			messageType: "type-1",
+		})
+	}
+	partitionKey := func(msg Message) string {
+		key, _, _ := strings.Cut(msg.(*mockMessage).id, "/")
+		return key
+	}
+
+	tests := []struct {
+		name string
+
+		process func(context.Context, map[MessageType]DocumentBuilder, ...Option) error
+	}{
+		{
+			name: "parallel processor",
+			process: func(ctx context.Context, builders map[MessageType]DocumentBuilder, opts ...Option) error {
+				_, err := NewParallelProcessor(builders, opts...).MakeDocuments(ctx, msgs)
+				return err
+			},
+		},
+		{
+			name: "stream processor",
+			process: func(ctx context.Context, builders map[MessageType]DocumentBuilder, opts ...Option) error {
+				return NewStreamProcessor(builders, opts...).ProcessSeq(
+					ctx,
+					slices.Values(msgs),
+					func(context.Context, Batch) error { return nil },
+				)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			builder := newPartitionTrackingBuilder(partitionKey)
+			err := test.process(
+				context.Background(),
+				map[MessageType]DocumentBuilder{"type-1": builder},
+				WithMaxWorkersOption(4),
+				WithPartitionKeyOption(partitionKey),
+			)
+			assert.NoError(t, err)
+
+			assert.False(t, builder.overlapped, "messages of the same partition ran at the same time")
+			assert.Greater(t, builder.maxRunning, 1, "partitions did not run in parallel")
+			for key, ids := range builder.built {
+				assert.True(t, slices.IsSorted(ids), "partition %s built out of order: %v", key, ids)
+				assert.Len(t, ids, 5)
+			}
+		})
+	}
+}
+
+func Test_PartitionKey_BatchDocumentBuilder(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu    sync.Mutex
+		built []string
+	)
+	record := func(msgs ...Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, msg := range msgs {
+			built = append(built, msg.(*mockMessage).id)
+		}
+	}
+
+	batchBuilder := &batchingDocumentBuilder{}
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": batchBuildFunc(func(ctx context.Context, msgs []Message) ([]BatchBuildResult, error) {
+				record(msgs...)
+				return batchBuilder.BuildBatch(ctx, msgs)
+			}),
+			"type-2": DocumentBuilderFunc(func(_ context.Context, msg Message) ([]Document, error) {
+				record(msg)
+				return []Document{mockDocument{id: msg.(*mockMessage).id}}, nil
+			}),
+		},
+		WithMaxWorkersOption(4),
+		WithPartitionKeyOption(func(Message) string { return "k" }),
+	)
+
+	// The single Message of type-2 comes between the ones of the chunk, so
+	// the chunk is split to keep the partition's order.
+	_, err := parallelProcessor.MakeDocuments(context.Background(), []Message{
+		&mockMessage{id: "0", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-2"},
+		&mockMessage{id: "2", namespace: "tiramisu", messageType: "type-1"},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0", "1", "2"}, built)
+	assert.Equal(t, []int{1, 1}, batchBuilder.batchSizes)
+}
+
+// batchBuildFunc is a BatchDocumentBuilder calling itself for Build too.
+type batchBuildFunc func(context.Context, []Message) ([]BatchBuildResult, error)
+
+func (f batchBuildFunc) Build(ctx context.Context, msg Message) ([]Document, error) {
+	results, err := f(ctx, []Message{msg})
+	if err != nil {
+		return nil, err
+	}
+	return results[0].Documents, results[0].Err
+}
+
+func (f batchBuildFunc) BuildBatch(ctx context.Context, msgs []Message) ([]BatchBuildResult, error) {
+	return f(ctx, msgs)
+}
+
+type partitionTrackingBuilder struct {
+	partitionKey MessageKeyFunc
+
+	mu         sync.Mutex
+	running    map[string]bool
+	overlapped bool
+	current    int
+	maxRunning int
+	built      map[string][]string
+}
+
+func newPartitionTrackingBuilder(partitionKey MessageKeyFunc) *partitionTrackingBuilder {
+	return &partitionTrackingBuilder{
+		partitionKey: partitionKey,
+		running:      make(map[string]bool),
+		built:        make(map[string][]string),
+	}
+}
+
+func (b *partitionTrackingBuilder) Build(_ context.Context, msg Message) ([]Document, error) {
+	key := b.partitionKey(msg)
+	id := msg.(*mockMessage).id
+
+	b.mu.Lock()
+	b.overlapped = b.overlapped || b.running[key]
+	b.running[key] = true
+	b.current++
+	b.maxRunning = max(b.maxRunning, b.current)
+	b.built[key] = append(b.built[key], id)
+	b.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	b.mu.Lock()
+	b.running[key] = false
+	b.current--
+	b.mu.Unlock()
+
+	return []Document{mockDocument{id: id}}, nil
+}
@@ -0,0 +1,28 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// recoverBuild returns a build function that converts a panic in build into an
+// ErrBuildPanic with ErrCodeBuildPanic associated with, so it fails the Message
+// like any other error instead of crashing the process.
+func recoverBuild[T any](build func(context.Context) (T, error)) func(context.Context) (T, error) {
+	return func(ctx context.Context) (output T, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = errors.E(
+					ErrBuildPanic,
+					ErrCodeBuildPanic,
+					errors.KV("panic", fmt.Sprint(r)),
+					errors.KV("stack", string(debug.Stack())),
+				)
+			}
+		}()
+		return build(ctx)
+	}
+}
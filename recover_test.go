@@ -0,0 +1,53 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MakeDocuments_BuilderPanic(t *testing.T) {
+	t.Parallel()
+
+	parallelProcessor := NewParallelProcessor(map[MessageType]DocumentBuilder{
+		"type-1": panickingDocumentBuilder{},
+	})
+
+	documents, err := parallelProcessor.MakeDocuments(context.Background(), []Message{
+		&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+	})
+	assert.Nil(t, documents)
+	assert.ErrorIs(t, err, ErrBuildPanic)
+	assert.Equal(t, ErrCodeBuildDocuments, errors.GetCode(err))
+	assert.Contains(t, err.Error(), "panic=boom")
+	assert.Contains(t, err.Error(), "stack=")
+}
+
+func Test_Process_BuilderPanic(t *testing.T) {
+	t.Parallel()
+
+	parallelProcessor := NewParallelProcessor(map[MessageType]DocumentBuilder{
+		"type-1": newConcurrencyTrackingBuilder(),
+		"type-2": panickingDocumentBuilder{},
+	})
+
+	result, err := parallelProcessor.Process(context.Background(), []Message{
+		&mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"},
+		&mockMessage{id: "2", namespace: "tiramisu", messageType: "type-2"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Document{mockDocument{id: "1"}}, result.Documents)
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, 1, result.Failures[0].Index)
+		assert.Equal(t, ErrCodeBuildPanic, result.Failures[0].Code)
+		assert.ErrorIs(t, result.Failures[0].Err, ErrBuildPanic)
+	}
+}
+
+type panickingDocumentBuilder struct{}
+
+func (panickingDocumentBuilder) Build(context.Context, Message) ([]Document, error) {
+	panic("boom")
+}
@@ -0,0 +1,68 @@
+package gomsgprocessor
+
+import (
+	"context"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// Result is the output of ParallelProcessor's Process.
+type Result struct {
+	// Documents are the deduplicated documents of all messages that were
+	// successfully built.
+	Documents []Document
+	// Failures holds one MessageFailure for each Message that failed to build,
+	// in input order.
+	Failures []MessageFailure
+	// Ignored holds one IgnoredMessage for each Message that built no
+	// documents nor child messages, in input order.
+	Ignored []IgnoredMessage
+	// Coalesced is the number of messages of each Namespace that were merged
+	// into another one before being built. See WithCoalesceMessagesOption.
+	Coalesced map[Namespace]int
+
+	// emitted holds the keys of the Documents to add on Commit.
+	emitted []emittedKeys
+}
+
+// Commit adds the keys of the Documents to the DedupStore of their Namespace,
+// so the next calls drop them (see WithDedupStoreOption). It must be called
+// once the Documents are written, and does nothing if no DedupStore is
+// configured.
+//
+// The returned error has a ErrCodeDeduplicateDocuments associated with.
+func (r Result) Commit(ctx context.Context) error {
+	const op = errors.Op("gomsgprocessor.Result.Commit")
+
+	err := addEmittedKeys(ctx, r.emitted...)
+	if err != nil {
+		return errors.E(op, err, ErrCodeDeduplicateDocuments)
+	}
+	return nil
+}
+
+// MessageFailure describes a Message that failed to build.
+type MessageFailure struct {
+	// Index is the position of the Message in the given []Message. For child
+	// messages of an ExpandingDocumentBuilder, it is the position of the input
+	// Message they descend from.
+	Index   int
+	Message Message
+	Err     error
+	// Code is the (foundationkit/errors).Code of Err. When Err has no code,
+	// ErrCodeBuildDocuments is used.
+	Code errors.Code
+}
+
+func newMessageFailure(index int, msg Message, err error) MessageFailure {
+	code := errors.GetCode(err)
+	if code == "" {
+		code = ErrCodeBuildDocuments
+	}
+	return MessageFailure{
+		Index:   index,
+		Message: msg,
+		Err:     err,
+		Code:    code,
+	}
+}
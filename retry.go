@@ -0,0 +1,104 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// RetryPolicy configures how a failed DocumentBuilder's Build is retried. The
+// zero value disables retries.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of Build calls for a single Message,
+	// including the first one. Values lower than 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts. Zero means no cap.
+	MaxBackoff time.Duration
+	// Multiplier is applied to the wait after each retry. Values lower than 1
+	// default to 2.
+	Multiplier float64
+	// Jitter is the fraction, between 0 and 1, of each wait that is randomly
+	// removed so retries of concurrent messages do not happen all together.
+	Jitter float64
+	// IsRetryable decides if an error must be retried. When nil, every error is
+	// retried. See RetryOnCodes.
+	IsRetryable func(error) bool
+}
+
+// RetryOnCodes returns a RetryPolicy's IsRetryable that retries only the errors
+// with one of the given (foundationkit/errors).Code.
+func RetryOnCodes(codes ...errors.Code) func(error) bool {
+	return func(err error) bool {
+		return slices.Contains(codes, errors.GetCode(err))
+	}
+}
+
+// retryPolicyFor returns the RetryPolicy registered for the MessageType,
+// falling back to the processor's default one.
+func (p *parallelProcessor) retryPolicyFor(messageType MessageType) RetryPolicy {
+	if policy, ok := p.retryPolicyByType[messageType]; ok {
+		return policy
+	}
+	return p.retryPolicy
+}
+
+// retryBuild calls build until it succeeds, the error is not retryable by r,
+// the attempts are exhausted or the context is done. The error of the last
+// attempt is returned.
+func retryBuild[T any](ctx context.Context, r RetryPolicy, build func(context.Context) (T, error)) (T, error) {
+	var zero T
+
+	backoff := r.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		output, err := build(ctx)
+		if err == nil {
+			return output, nil
+		}
+		if attempt >= r.MaxAttempts || (r.IsRetryable != nil && !r.IsRetryable(err)) {
+			if attempt > 1 {
+				err = errors.E(err, errors.KV("attempts", attempt))
+			}
+			return zero, err
+		}
+
+		log.Ctx(ctx).Debug().Err(err).Int("attempt", attempt).Msg("Retrying document build...")
+
+		timer := time.NewTimer(r.jittered(backoff))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return zero, errors.E(err, errors.KV("attempts", attempt))
+		case <-timer.C:
+		}
+
+		backoff = r.next(backoff)
+	}
+}
+
+func (r RetryPolicy) jittered(backoff time.Duration) time.Duration {
+	if r.Jitter <= 0 || backoff <= 0 {
+		return backoff
+	}
+	jitter := min(r.Jitter, 1)
+	//nolint:gosec // jitter does not need a secure random number.
+	return backoff - time.Duration(jitter*rand.Float64()*float64(backoff))
+}
+
+func (r RetryPolicy) next(backoff time.Duration) time.Duration {
+	multiplier := r.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	backoff = time.Duration(float64(backoff) * multiplier)
+	if r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+		return r.MaxBackoff
+	}
+	return backoff
+}
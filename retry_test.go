@@ -0,0 +1,142 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MakeDocuments_Retry(t *testing.T) {
+	t.Parallel()
+
+	transientErr := errors.E(errors.New("transient error"), errors.Code("TRANSIENT"))
+	permanentErr := errors.E(errors.New("permanent error"), errors.Code("PERMANENT"))
+
+	tests := []struct {
+		name string
+
+		errs []error
+		opts []Option
+
+		expectedCalls int
+		expectedError string
+	}{
+		{
+			name: "success after retries",
+			errs: []error{transientErr, transientErr},
+			opts: []Option{
+				WithRetryPolicyOption(RetryPolicy{
+					MaxAttempts:    3,
+					InitialBackoff: time.Millisecond,
+					Jitter:         0.5,
+				}),
+			},
+			expectedCalls: 3,
+		},
+		{
+			name: "success after retries - message type policy",
+			errs: []error{transientErr},
+			opts: []Option{
+				WithMessageTypeRetryPolicyOption("type-1", RetryPolicy{
+					MaxAttempts: 2,
+				}),
+			},
+			expectedCalls: 2,
+		},
+		{
+			name: "errors - attempts exhausted",
+			errs: []error{transientErr, transientErr, transientErr},
+			opts: []Option{
+				WithRetryPolicyOption(RetryPolicy{
+					MaxAttempts:    2,
+					InitialBackoff: time.Millisecond,
+				}),
+			},
+			expectedCalls: 2,
+			expectedError: "gomsgprocessor.parallelProcessor.MakeDocuments: parallelBuildDocumentsByNamespace: transient error [attempts=2]",
+		},
+		{
+			name: "errors - not retryable",
+			errs: []error{permanentErr},
+			opts: []Option{
+				WithRetryPolicyOption(RetryPolicy{
+					MaxAttempts: 3,
+					IsRetryable: RetryOnCodes("TRANSIENT"),
+				}),
+			},
+			expectedCalls: 1,
+			expectedError: "gomsgprocessor.parallelProcessor.MakeDocuments: parallelBuildDocumentsByNamespace: permanent error",
+		},
+		{
+			name:          "errors - no retry policy",
+			errs:          []error{transientErr},
+			expectedCalls: 1,
+			expectedError: "gomsgprocessor.parallelProcessor.MakeDocuments: parallelBuildDocumentsByNamespace: transient error",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			builder := &flakyDocumentBuilder{errs: test.errs}
+			parallelProcessor := NewParallelProcessor(
+				map[MessageType]DocumentBuilder{
+					"type-1": builder,
+				},
+				test.opts...,
+			)
+
+			documents, err := parallelProcessor.MakeDocuments(
+				context.Background(),
+				makeMockMessages(1, "tiramisu", "type-1"),
+			)
+
+			if test.expectedError == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, []Document{mockDocument{id: "tiramisu-type-1-0"}}, documents)
+			} else {
+				assert.EqualError(t, err, test.expectedError)
+			}
+			assert.Equal(t, test.expectedCalls, builder.calls)
+		})
+	}
+}
+
+func Test_RetryPolicy_ContextCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Hour,
+	}
+
+	calls := 0
+	_, err := retryBuild(ctx, policy, func(context.Context) (buildOutput, error) {
+		calls++
+		cancel()
+		return buildOutput{}, errors.New("transient error")
+	})
+
+	assert.EqualError(t, err, "transient error [attempts=1]")
+	assert.Equal(t, 1, calls)
+}
+
+// flakyDocumentBuilder fails with the given errors, one per call, and then
+// succeeds. It must be used with a single message.
+type flakyDocumentBuilder struct {
+	errs  []error
+	calls int
+}
+
+func (b *flakyDocumentBuilder) Build(_ context.Context, msg Message) ([]Document, error) {
+	b.calls++
+	if b.calls <= len(b.errs) {
+		return nil, b.errs[b.calls-1]
+	}
+	return []Document{mockDocument{id: msg.(*mockMessage).id}}, nil
+}
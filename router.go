@@ -0,0 +1,81 @@
+package gomsgprocessor
+
+import (
+	"path"
+	"strings"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// Router picks the DocumentBuilder of a Message whose MessageType is not in
+// the builders map, like versioned message types. It may look at any field of
+// the Message.
+//
+// The DocumentBuilder of a Message is the first found in this order:
+//  1. the builders map, by exact MessageType;
+//  2. the routers, in the order they were given to WithRouterOption;
+//  3. the fallback for unknown message types (see
+//     WithDefaultDocumentBuilderOption).
+type Router interface {
+	// Route returns the DocumentBuilder of the Message, or false if the
+	// Router has none for it.
+	Route(Message) (DocumentBuilder, bool)
+}
+
+// RouterFunc is an adapter to use an ordinary function as a Router.
+type RouterFunc func(Message) (DocumentBuilder, bool)
+
+// Route calls f(msg).
+func (f RouterFunc) Route(msg Message) (DocumentBuilder, bool) {
+	return f(msg)
+}
+
+// PrefixRouter returns a Router that routes the messages whose MessageType
+// starts with prefix to the given DocumentBuilder.
+func PrefixRouter(prefix string, builder DocumentBuilder) Router {
+	return RouterFunc(func(msg Message) (DocumentBuilder, bool) {
+		return builder, strings.HasPrefix(string(msg.GetType()), prefix)
+	})
+}
+
+// GlobRouter returns a Router that routes the messages whose MessageType
+// matches pattern to the given DocumentBuilder. The pattern syntax is the same
+// of path.Match, like "nfe.v*". It returns an error if the pattern is
+// malformed.
+func GlobRouter(pattern string, builder DocumentBuilder) (Router, error) {
+	const op = errors.Op("gomsgprocessor.GlobRouter")
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, errors.E(op, err, errors.KV("pattern", pattern))
+	}
+
+	return RouterFunc(func(msg Message) (DocumentBuilder, bool) {
+		matched, _ := path.Match(pattern, string(msg.GetType()))
+		return builder, matched
+	}), nil
+}
+
+// builderFor returns the DocumentBuilder of the Message from the builders map
+// or the routers. Builders found by routers are wrapped with the middlewares
+// on every call.
+func (p *parallelProcessor) builderFor(msg Message) (DocumentBuilder, bool) {
+	builder, _, ok := p.lookupBuilder(msg)
+	return builder, ok
+}
+
+// lookupBuilder is like builderFor, but also returns the DocumentBuilder found
+// by a router before being wrapped with the middlewares, or nil if the builder
+// came from the builders map.
+func (p *parallelProcessor) lookupBuilder(msg Message) (builder, routed DocumentBuilder, ok bool) {
+	if builder, ok := p.builders[msg.GetType()]; ok {
+		return builder, nil, true
+	}
+
+	for _, router := range p.routers {
+		if routed, ok := router.Route(msg); ok {
+			builder = chainBuilderMiddlewares(routed, p.middlewaresByType[msg.GetType()]...)
+			return chainBuilderMiddlewares(builder, p.middlewares...), routed, true
+		}
+	}
+	return nil, nil, false
+}
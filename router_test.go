@@ -0,0 +1,57 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MakeDocuments_Routers(t *testing.T) {
+	t.Parallel()
+
+	globRouter, err := GlobRouter("nfe.v[34]", namedDocumentBuilder("glob"))
+	assert.NoError(t, err)
+
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{"nfe.v4": namedDocumentBuilder("exact")},
+		WithRouterOption(
+			globRouter,
+			PrefixRouter("nfe.", namedDocumentBuilder("prefix")),
+			RouterFunc(func(msg Message) (DocumentBuilder, bool) {
+				return namedDocumentBuilder("custom"), msg.GetNamespace() == "potato"
+			}),
+		),
+		WithDefaultDocumentBuilderOption(namedDocumentBuilder("default")),
+	)
+
+	documents, err := parallelProcessor.MakeDocuments(context.Background(), []Message{
+		&mockMessage{id: "1", namespace: "tiramisu", messageType: "nfe.v4"},
+		&mockMessage{id: "2", namespace: "tiramisu", messageType: "nfe.v3"},
+		&mockMessage{id: "3", namespace: "tiramisu", messageType: "nfe.v2"},
+		&mockMessage{id: "4", namespace: "tiramisu", messageType: "cte.v1"},
+		&mockMessage{id: "5", namespace: "potato", messageType: "cte.v1"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Document{
+		mockDocument{id: "exact-1"},
+		mockDocument{id: "glob-2"},
+		mockDocument{id: "prefix-3"},
+		mockDocument{id: "default-4"},
+		mockDocument{id: "custom-5"},
+	}, documents)
+}
+
+func Test_GlobRouter_BadPattern(t *testing.T) {
+	t.Parallel()
+
+	router, err := GlobRouter("nfe.[", namedDocumentBuilder("glob"))
+	assert.Nil(t, router)
+	assert.Error(t, err)
+}
+
+func namedDocumentBuilder(name string) DocumentBuilder {
+	return DocumentBuilderFunc(func(_ context.Context, msg Message) ([]Document, error) {
+		return []Document{mockDocument{id: name + "-" + msg.(*mockMessage).id}}, nil
+	})
+}
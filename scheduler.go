@@ -0,0 +1,321 @@
+package gomsgprocessor
+
+import (
+	"container/heap"
+	"context"
+	"slices"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// concurrencyLimits holds how many Build calls may run at the same time, in
+// total and for each MessageType and Namespace. Values lower than 1 mean no
+// limit.
+type concurrencyLimits struct {
+	maxWorkers    int
+	byMessageType map[MessageType]int
+	byNamespace   map[Namespace]int
+}
+
+// task is a unit of work dispatched by the scheduler.
+type task struct {
+	index       int
+	messageType MessageType
+	namespace   Namespace
+	// partitionKeys are the keys of the partitions the task belongs to. Tasks
+	// of the same partition never run at the same time and run in index
+	// order.
+	partitionKeys []string
+}
+
+type taskQueueKey struct {
+	messageType MessageType
+	namespace   Namespace
+}
+
+// taskHeap is a min-heap of tasks ordered by index. It implements
+// heap.Interface.
+type taskHeap []task
+
+func (h taskHeap) Len() int           { return len(h) }
+func (h taskHeap) Less(i, j int) bool { return h[i].index < h[j].index }
+func (h taskHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *taskHeap) Push(x any) {
+	*h = append(*h, x.(task))
+}
+
+func (h *taskHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
+
+// scheduler dispatches tasks respecting the concurrencyLimits. Tasks are kept
+// in one queue for each MessageType and Namespace pair, so a saturated builder
+// or namespace never holds worker slots, nor blocks the tasks behind it, that
+// the others could use. Tasks waiting for their partition are kept apart, in
+// one FIFO for each partition, and only enter their queue when they are the
+// next one of all their partitions and none of them is running.
+type scheduler struct {
+	limits concurrencyLimits
+
+	mu                 sync.Mutex
+	running            int
+	runningByType      map[MessageType]int
+	runningByNamespace map[Namespace]int
+	runningPartitions  map[string]bool
+	// changed is closed, and replaced, whenever a task is pushed, dispatched
+	// or released.
+	changed chan struct{}
+
+	// keys holds the queues in the order they were created.
+	keys []taskQueueKey
+	// ready holds the pushed tasks that can be dispatched, by queue.
+	ready map[taskQueueKey]*taskHeap
+	// partitions holds the pushed tasks not yet dispatched, by partition key,
+	// in index order.
+	partitions map[string][]task
+	// queued is how many pushed tasks were not dispatched yet.
+	queued int
+	// closed is set when no more tasks are pushed.
+	closed bool
+}
+
+func newScheduler(limits concurrencyLimits) *scheduler {
+	return &scheduler{
+		limits:             limits,
+		runningByType:      make(map[MessageType]int),
+		runningByNamespace: make(map[Namespace]int),
+		runningPartitions:  make(map[string]bool),
+		changed:            make(chan struct{}),
+		ready:              make(map[taskQueueKey]*taskHeap),
+		partitions:         make(map[string][]task),
+	}
+}
+
+// run calls fn for every task, in input order as far as the limits allow. It
+// stops dispatching tasks when the context is done or fn fails and returns the
+// first error returned by fn, like an errgroup.
+func (s *scheduler) run(
+	ctx context.Context,
+	tasks []task,
+	fn func(context.Context, task) error,
+) error {
+	s.mu.Lock()
+	for _, t := range tasks {
+		s.enqueue(t)
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	return s.dispatch(ctx, fn)
+}
+
+// push adds a task to be dispatched, blocking while maxQueued tasks wait to be
+// dispatched. A value lower than 1 means no limit. Tasks must be pushed in
+// index order. It returns the context's error if the context is done first.
+func (s *scheduler) push(ctx context.Context, t task, maxQueued int) error {
+	for {
+		s.mu.Lock()
+		if maxQueued < 1 || s.queued < maxQueued {
+			s.enqueue(t)
+			s.notify()
+			s.mu.Unlock()
+			return nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// close tells dispatch that no more tasks are pushed.
+func (s *scheduler) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.notify()
+}
+
+// dispatch calls fn for every pushed task, in index order as far as the limits
+// allow, until the scheduler is closed and all of them were dispatched. It
+// stops dispatching tasks when the context is done or fn fails and returns the
+// first error returned by fn, like an errgroup.
+func (s *scheduler) dispatch(ctx context.Context, fn func(context.Context, task) error) error {
+	// stop is called before the slot of a failed task is released, so no task
+	// is dispatched after the first failure.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	g, gctx := errgroup.WithContext(ctx)
+
+	aborted := false
+	for !aborted {
+		if gctx.Err() != nil {
+			aborted = true
+			break
+		}
+
+		t, changed, ok := s.acquireNext()
+		if !ok {
+			if changed == nil {
+				break
+			}
+			select {
+			case <-gctx.Done():
+				aborted = true
+			case <-changed:
+			}
+			continue
+		}
+
+		g.Go(func() error {
+			defer s.release(t)
+			err := fn(gctx, t)
+			if err != nil {
+				stop()
+			}
+			return err
+		})
+	}
+
+	err := g.Wait()
+	if err == nil && aborted {
+		return ctx.Err()
+	}
+	return err
+}
+
+// enqueue adds the task to its partitions and, if it is the next one of all of
+// them, to its queue. It must be called with mu locked.
+func (s *scheduler) enqueue(t task) {
+	key := taskQueueKey{messageType: t.messageType, namespace: t.namespace}
+	if _, ok := s.ready[key]; !ok {
+		s.keys = append(s.keys, key)
+		s.ready[key] = &taskHeap{}
+	}
+	for _, partitionKey := range t.partitionKeys {
+		s.partitions[partitionKey] = append(s.partitions[partitionKey], t)
+	}
+	if s.isNextOfPartitions(t) {
+		heap.Push(s.ready[key], t)
+	}
+	s.queued++
+}
+
+// acquireNext takes the earliest ready task of the queues with room and
+// reserves a slot for it. If no task can run, it returns a channel that is
+// closed on the next change, or nil if the scheduler is closed and every task
+// was dispatched.
+func (s *scheduler) acquireNext() (task, <-chan struct{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		best  *taskHeap
+		found bool
+	)
+	for _, key := range s.keys {
+		queue := s.ready[key]
+		if queue.Len() == 0 || !s.hasRoomFor(key) {
+			continue
+		}
+		if !found || (*queue)[0].index < (*best)[0].index {
+			best, found = queue, true
+		}
+	}
+	if !found {
+		if s.closed && s.queued == 0 {
+			return task{}, nil, false
+		}
+		return task{}, s.changed, false
+	}
+
+	t := heap.Pop(best).(task)
+	for _, partitionKey := range t.partitionKeys {
+		s.partitions[partitionKey] = s.partitions[partitionKey][1:]
+	}
+	s.reserve(t)
+	s.queued--
+	s.notify()
+	return t, nil, true
+}
+
+// isNextOfPartitions returns true if none of the task's partitions is running
+// and the task is the next one of each of them. It must be called with mu
+// locked.
+func (s *scheduler) isNextOfPartitions(t task) bool {
+	for _, partitionKey := range t.partitionKeys {
+		if s.runningPartitions[partitionKey] {
+			return false
+		}
+		if next := s.partitions[partitionKey]; len(next) > 0 && next[0].index != t.index {
+			return false
+		}
+	}
+	return true
+}
+
+// notify wakes up whoever waits on changed. It must be called with mu locked.
+func (s *scheduler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *scheduler) hasRoomFor(key taskQueueKey) bool {
+	if s.limits.maxWorkers > 0 && s.running >= s.limits.maxWorkers {
+		return false
+	}
+	if limit := s.limits.byMessageType[key.messageType]; limit > 0 &&
+		s.runningByType[key.messageType] >= limit {
+		return false
+	}
+	if limit := s.limits.byNamespace[key.namespace]; limit > 0 &&
+		s.runningByNamespace[key.namespace] >= limit {
+		return false
+	}
+	return true
+}
+
+func (s *scheduler) reserve(t task) {
+	s.running++
+	s.runningByType[t.messageType]++
+	s.runningByNamespace[t.namespace]++
+	for _, partitionKey := range t.partitionKeys {
+		s.runningPartitions[partitionKey] = true
+	}
+}
+
+func (s *scheduler) release(t task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running--
+	s.runningByType[t.messageType]--
+	s.runningByNamespace[t.namespace]--
+	for _, partitionKey := range t.partitionKeys {
+		delete(s.runningPartitions, partitionKey)
+	}
+
+	// The next task of each released partition may be ready now. A task
+	// sharing many of them is only queued once.
+	queued := make([]int, 0, len(t.partitionKeys))
+	for _, partitionKey := range t.partitionKeys {
+		next := s.partitions[partitionKey]
+		if len(next) == 0 || slices.Contains(queued, next[0].index) || !s.isNextOfPartitions(next[0]) {
+			continue
+		}
+		queued = append(queued, next[0].index)
+		heap.Push(s.ready[taskQueueKey{messageType: next[0].messageType, namespace: next[0].namespace}], next[0])
+	}
+
+	s.notify()
+}
@@ -0,0 +1,209 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MakeDocuments_ConcurrencyLimits(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+
+		messages []Message
+		opts     []Option
+
+		expectedMaxRunning            int
+		expectedMaxRunningByType      map[MessageType]int
+		expectedMaxRunningByNamespace map[Namespace]int
+	}{
+		{
+			name:               "max workers",
+			messages:           makeMockMessages(20, "tiramisu", "type-1"),
+			opts:               []Option{WithMaxWorkersOption(3)},
+			expectedMaxRunning: 3,
+		},
+		{
+			name: "max workers by message type",
+			messages: append(
+				makeMockMessages(10, "tiramisu", "type-1"),
+				makeMockMessages(10, "tiramisu", "type-2")...,
+			),
+			opts: []Option{
+				WithMaxWorkersOption(4),
+				WithMessageTypeMaxWorkersOption("type-1", 1),
+			},
+			expectedMaxRunning:       4,
+			expectedMaxRunningByType: map[MessageType]int{"type-1": 1},
+		},
+		{
+			name: "max workers by namespace",
+			messages: append(
+				makeMockMessages(10, "tiramisu", "type-1"),
+				makeMockMessages(10, "potato", "type-1")...,
+			),
+			opts: []Option{
+				WithNamespaceMaxWorkersOption("tiramisu", 2),
+				WithNamespaceMaxWorkersOption("potato", 1),
+			},
+			expectedMaxRunningByNamespace: map[Namespace]int{"tiramisu": 2, "potato": 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			builder := newConcurrencyTrackingBuilder()
+			parallelProcessor := NewParallelProcessor(
+				map[MessageType]DocumentBuilder{
+					"type-1": builder,
+					"type-2": builder,
+				},
+				test.opts...,
+			)
+
+			documents, err := parallelProcessor.MakeDocuments(context.Background(), test.messages)
+			assert.NoError(t, err)
+			assert.Len(t, documents, len(test.messages))
+
+			if test.expectedMaxRunning > 0 {
+				assert.LessOrEqual(t, builder.maxRunning, test.expectedMaxRunning)
+			}
+			for messageType, limit := range test.expectedMaxRunningByType {
+				assert.LessOrEqual(t, builder.maxRunningByType[messageType], limit)
+			}
+			for namespace, limit := range test.expectedMaxRunningByNamespace {
+				assert.LessOrEqual(t, builder.maxRunningByNamespace[namespace], limit)
+			}
+		})
+	}
+}
+
+func makeMockMessages(n int, namespace Namespace, messageType MessageType) []Message {
+	msgs := make([]Message, 0, n)
+	for i := range n {
+		msgs = append(msgs, &mockMessage{
+			id:          fmt.Sprintf("%s-%s-%d", namespace, messageType, i),
+			namespace:   namespace,
+			messageType: messageType,
+		})
+	}
+	return msgs
+}
+
+func Test_scheduler_Partitions(t *testing.T) {
+	t.Parallel()
+
+	tasks := []task{
+		{index: 0, partitionKeys: []string{"a"}},
+		{index: 1, partitionKeys: []string{"a", "b"}},
+		{index: 2, partitionKeys: []string{"b"}},
+		{index: 3, partitionKeys: []string{"c"}},
+		{index: 4, partitionKeys: []string{"a", "c"}},
+		{index: 5},
+	}
+
+	var (
+		mu      sync.Mutex
+		running = make(map[string]bool)
+		started = make(map[string][]int)
+	)
+	err := newScheduler(concurrencyLimits{maxWorkers: 4}).run(
+		context.Background(),
+		tasks,
+		func(_ context.Context, tk task) error {
+			mu.Lock()
+			for _, key := range tk.partitionKeys {
+				assert.False(t, running[key], "partition %s ran at the same time", key)
+				running[key] = true
+				started[key] = append(started[key], tk.index)
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			for _, key := range tk.partitionKeys {
+				running[key] = false
+			}
+			mu.Unlock()
+			return nil
+		},
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]int{"a": {0, 1, 4}, "b": {1, 2}, "c": {3, 4}}, started)
+}
+
+func Test_scheduler_StopsOnFailure(t *testing.T) {
+	t.Parallel()
+
+	tasks := make([]task, 100)
+	for i := range tasks {
+		tasks[i].index = i
+	}
+
+	var calls atomic.Int32
+	err := newScheduler(concurrencyLimits{maxWorkers: 1}).run(
+		context.Background(),
+		tasks,
+		func(context.Context, task) error {
+			calls.Add(1)
+			return errors.New("build error")
+		},
+	)
+
+	assert.EqualError(t, err, "build error")
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+type concurrencyTrackingBuilder struct {
+	mu                    sync.Mutex
+	running               int
+	maxRunning            int
+	runningByType         map[MessageType]int
+	maxRunningByType      map[MessageType]int
+	runningByNamespace    map[Namespace]int
+	maxRunningByNamespace map[Namespace]int
+}
+
+func newConcurrencyTrackingBuilder() *concurrencyTrackingBuilder {
+	return &concurrencyTrackingBuilder{
+		runningByType:         make(map[MessageType]int),
+		maxRunningByType:      make(map[MessageType]int),
+		runningByNamespace:    make(map[Namespace]int),
+		maxRunningByNamespace: make(map[Namespace]int),
+	}
+}
+
+func (b *concurrencyTrackingBuilder) Build(_ context.Context, msg Message) ([]Document, error) {
+	b.mu.Lock()
+	b.running++
+	b.maxRunning = max(b.maxRunning, b.running)
+	b.runningByType[msg.GetType()]++
+	b.maxRunningByType[msg.GetType()] = max(b.maxRunningByType[msg.GetType()], b.runningByType[msg.GetType()])
+	b.runningByNamespace[msg.GetNamespace()]++
+	b.maxRunningByNamespace[msg.GetNamespace()] = max(
+		b.maxRunningByNamespace[msg.GetNamespace()],
+		b.runningByNamespace[msg.GetNamespace()],
+	)
+	b.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	b.mu.Lock()
+	b.running--
+	b.runningByType[msg.GetType()]--
+	b.runningByNamespace[msg.GetNamespace()]--
+	b.mu.Unlock()
+
+	return []Document{mockDocument{id: msg.(*mockMessage).id}}, nil
+}
@@ -0,0 +1,321 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"iter"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/arquivei/foundationkit/trace"
+)
+
+// Batch is a group of documents of the same Namespace emitted by a
+// StreamProcessor.
+type Batch struct {
+	Namespace Namespace
+	Documents []Document
+}
+
+// BatchHandler receives the batches emitted by a StreamProcessor. It is never
+// called concurrently.
+type BatchHandler func(context.Context, Batch) error
+
+// StreamProcessor is a interface that process in parallel a stream of Message,
+// emitting documents continuously instead of returning them at the end.
+type StreamProcessor interface {
+	// ProcessSeq reads messages from the iterator and builds their documents
+	// in parallel using the map of DocumentBuilder (see NewStreamProcessor).
+	// Documents are grouped by Namespace and sent to the BatchHandler, after
+	// being deduplicated, when the batch size or the flush interval is reached
+	// (see WithStreamBatchSizeOption and WithStreamFlushIntervalOption).
+	//
+	// When the context is done, it stops reading messages, waits for the
+	// messages being built and flushes every pending batch before returning
+	// nil. Otherwise it returns when the iterator ends and all batches were
+	// flushed.
+	//
+	// The first failure aborts the stream and discards pending batches. The
+	// returned error has a (foundationkit/errors).Code associated with and can
+	// be a ErrCodeBuildDocuments, a ErrCodeDeduplicateDocuments or a
+	// ErrCodeHandleBatch.
+	ProcessSeq(context.Context, iter.Seq[Message], BatchHandler) error
+
+	// ProcessChannel is like ProcessSeq, but reads messages from the channel
+	// until it is closed.
+	ProcessChannel(context.Context, <-chan Message, BatchHandler) error
+}
+
+// streamConfig holds the thresholds used to flush batches in a
+// StreamProcessor. Values lower than 1 disable the threshold.
+type streamConfig struct {
+	batchSize     int
+	flushInterval time.Duration
+}
+
+type streamProcessor struct {
+	*parallelProcessor
+}
+
+// NewStreamProcessor returns a new StreamProcessor with a map of
+// DocumentBuilder for each MessageType.
+//
+// It accepts the same Option as NewParallelProcessor. If WithMaxWorkersOption
+// is not set, the number of workers defaults to GOMAXPROCS.
+//
+// Messages waiting for a MessageType or Namespace limit (see
+// WithMessageTypeMaxWorkersOption and WithNamespaceMaxWorkersOption) are
+// queued while the next messages are read and built. Up to as many messages
+// as workers are queued, reading waits when the queue is full.
+func NewStreamProcessor(
+	builders map[MessageType]DocumentBuilder,
+	opts ...Option,
+) StreamProcessor {
+	return &streamProcessor{
+		parallelProcessor: newParallelProcessor(builders, opts...),
+	}
+}
+
+func (p *streamProcessor) ProcessChannel(
+	ctx context.Context,
+	msgs <-chan Message,
+	handle BatchHandler,
+) error {
+	return p.process(ctx, func(readCtx context.Context) iter.Seq[Message] {
+		return channelSeq(readCtx, msgs)
+	}, handle)
+}
+
+func (p *streamProcessor) ProcessSeq(
+	ctx context.Context,
+	msgs iter.Seq[Message],
+	handle BatchHandler,
+) error {
+	return p.process(ctx, func(context.Context) iter.Seq[Message] {
+		return msgs
+	}, handle)
+}
+
+// process runs the stream over the iterator returned by seq. The iterator is
+// built on the context used to stop reading, which is done when ctx is done or
+// the stream is aborted, so blocking iterators can return early.
+func (p *streamProcessor) process(
+	ctx context.Context,
+	seq func(readCtx context.Context) iter.Seq[Message],
+	handle BatchHandler,
+) error {
+	const op = errors.Op("gomsgprocessor.streamProcessor.ProcessSeq")
+
+	ctx, span := trace.StartSpan(ctx, op.String())
+	defer span.End(nil)
+
+	// Builds and handlers outlive ctx so the stream can be drained, they are
+	// only canceled when the stream is aborted.
+	buildCtx, abort := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer abort(nil)
+
+	readCtx, stopReading := context.WithCancel(ctx)
+	defer stopReading()
+	context.AfterFunc(buildCtx, stopReading)
+
+	limits := p.concurrency
+	if limits.maxWorkers < 1 {
+		limits.maxWorkers = runtime.GOMAXPROCS(0)
+	}
+	scheduler := newScheduler(limits)
+
+	builtMessages := make(chan builtMessage)
+	batcherErr := make(chan error, 1)
+	go func() {
+		batcherErr <- p.batchDocuments(buildCtx, builtMessages, handle, abort)
+	}()
+
+	// The loaders are shared by all messages, so their concurrent loads are
+	// coalesced, but keep no value to not grow with the stream.
+	messageCtx := p.withLoaders(buildCtx, false)
+
+	// Read messages wait in the scheduler's queues, so a message waiting for a
+	// MessageType or Namespace limit does not hold back the ones read after it.
+	var (
+		mu       sync.Mutex
+		messages = make(map[int]Message)
+	)
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		// Failures abort the stream instead of returning an error, so the
+		// error of dispatch is already the cause of buildCtx.
+		_ = scheduler.dispatch(buildCtx, func(_ context.Context, t task) error {
+			mu.Lock()
+			msg := messages[t.index]
+			delete(messages, t.index)
+			mu.Unlock()
+
+			builtMsg := builtMessage{message: msg}
+			err := p.buildMessageTree(messageCtx, &builtMsg)
+			if err != nil {
+				abort(errors.E(err, ErrCodeBuildDocuments))
+				return nil
+			}
+
+			select {
+			case builtMessages <- builtMsg:
+			case <-buildCtx.Done():
+			}
+			return nil
+		})
+	}()
+
+	index := 0
+	for msg := range seq(readCtx) {
+		t := task{
+			index:         index,
+			messageType:   msg.GetType(),
+			namespace:     msg.GetNamespace(),
+			partitionKeys: p.partitionKeys(msg),
+		}
+		index++
+
+		mu.Lock()
+		messages[t.index] = msg
+		mu.Unlock()
+
+		// A message already read is built even if ctx is done, so it is not
+		// lost. It is only dropped when the stream is aborted.
+		if scheduler.push(buildCtx, t, limits.maxWorkers) != nil {
+			break
+		}
+
+		if readCtx.Err() != nil {
+			break
+		}
+	}
+
+	scheduler.close()
+	<-dispatched
+	close(builtMessages)
+
+	if err := <-batcherErr; err != nil {
+		return errors.E(op, err)
+	}
+	if err := context.Cause(buildCtx); err != nil {
+		return errors.E(op, err)
+	}
+	return nil
+}
+
+// batchDocuments groups built documents by Namespace and flushes them to the
+// BatchHandler. It flushes every pending batch when builtMessages is closed.
+func (p *streamProcessor) batchDocuments(
+	ctx context.Context,
+	builtMessages <-chan builtMessage,
+	handle BatchHandler,
+	abort context.CancelCauseFunc,
+) error {
+	var (
+		namespaces []Namespace
+		pending    = make(map[Namespace][]Document)
+		tick       <-chan time.Time
+	)
+
+	if p.stream.flushInterval > 0 {
+		ticker := time.NewTicker(p.stream.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	flush := func(namespace Namespace) error {
+		documents := pending[namespace]
+		if len(documents) == 0 {
+			return nil
+		}
+		pending[namespace] = nil
+
+		unlock := p.lockDedupStores()
+		defer unlock()
+
+		deduplicatedDocuments, emitted, err := p.deduplicateNamespace(ctx, namespace, documents)
+		if err != nil {
+			return errors.E(err, ErrCodeDeduplicateDocuments)
+		}
+		if len(deduplicatedDocuments) == 0 {
+			return nil
+		}
+
+		err = handle(ctx, Batch{Namespace: namespace, Documents: deduplicatedDocuments})
+		if err != nil {
+			return errors.E(err, ErrCodeHandleBatch)
+		}
+
+		// The keys are only added once the batch is handled, so a failed batch
+		// is emitted again when its messages are redelivered.
+		err = addEmittedKeys(ctx, emitted)
+		if err != nil {
+			return errors.E(err, ErrCodeDeduplicateDocuments)
+		}
+		return nil
+	}
+
+	flushAll := func() error {
+		if p.namespaceOrder == NamespaceOrderSorted {
+			slices.Sort(namespaces)
+		}
+		for _, namespace := range namespaces {
+			if err := flush(namespace); err != nil {
+				return err
+			}
+		}
+		namespaces = namespaces[:0]
+		clear(pending)
+		return nil
+	}
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick:
+			err = flushAll()
+		case tree, ok := <-builtMessages:
+			if !ok {
+				return flushAll()
+			}
+			walkBuiltMessages([]builtMessage{tree}, func(builtMsg *builtMessage) {
+				if err != nil || builtMsg.documents == nil || builtMsg.namespace == "" {
+					return
+				}
+
+				if _, ok := pending[builtMsg.namespace]; !ok {
+					namespaces = append(namespaces, builtMsg.namespace)
+				}
+				pending[builtMsg.namespace] = append(pending[builtMsg.namespace], builtMsg.documents...)
+
+				if p.stream.batchSize > 0 && len(pending[builtMsg.namespace]) >= p.stream.batchSize {
+					err = flush(builtMsg.namespace)
+				}
+			})
+		}
+		if err != nil {
+			abort(err)
+			return err
+		}
+	}
+}
+
+func channelSeq(ctx context.Context, msgs <-chan Message) iter.Seq[Message] {
+	return func(yield func(Message) bool) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok || !yield(msg) {
+					return
+				}
+			}
+		}
+	}
+}
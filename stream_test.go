@@ -0,0 +1,251 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_StreamProcessor_ProcessChannel(t *testing.T) {
+	t.Parallel()
+
+	msgs := make(chan Message)
+	go func() {
+		defer close(msgs)
+		for _, msg := range append(
+			makeMockMessages(5, "tiramisu", "type-1"),
+			makeMockMessages(3, "potato", "type-1")...,
+		) {
+			msgs <- msg
+		}
+	}()
+
+	streamProcessor := NewStreamProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": newConcurrencyTrackingBuilder(),
+		},
+		WithMaxWorkersOption(2),
+		WithStreamBatchSizeOption(2),
+	)
+
+	documentsByNamespace := make(map[Namespace][]Document)
+	err := streamProcessor.ProcessChannel(context.Background(), msgs, func(_ context.Context, batch Batch) error {
+		assert.LessOrEqual(t, len(batch.Documents), 2)
+		documentsByNamespace[batch.Namespace] = append(documentsByNamespace[batch.Namespace], batch.Documents...)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Len(t, documentsByNamespace["tiramisu"], 5)
+	assert.Len(t, documentsByNamespace["potato"], 3)
+}
+
+func Test_StreamProcessor_ProcessChannel_ContextCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The channel is never closed, the stream must be drained when ctx is
+	// canceled.
+	msgs := make(chan Message)
+	go func() {
+		for _, msg := range makeMockMessages(5, "tiramisu", "type-1") {
+			msgs <- msg
+		}
+		cancel()
+	}()
+
+	streamProcessor := NewStreamProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": newConcurrencyTrackingBuilder(),
+		},
+		WithMaxWorkersOption(2),
+		WithStreamBatchSizeOption(100),
+	)
+
+	documents := 0
+	err := streamProcessor.ProcessChannel(ctx, msgs, func(_ context.Context, batch Batch) error {
+		documents += len(batch.Documents)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, documents)
+}
+
+func Test_StreamProcessor_ProcessChannel_Abort(t *testing.T) {
+	t.Parallel()
+
+	// The channel is never closed, the stream must stop reading it when a
+	// build fails.
+	msgs := make(chan Message)
+	go func() {
+		msgs <- &mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"}
+	}()
+
+	builder := &mockDocumentBuilder{}
+	builder.On("Build", mock.Anything).Return(nil, errors.New("build error"))
+
+	streamProcessor := NewStreamProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": builder,
+		},
+	)
+
+	err := streamProcessor.ProcessChannel(context.Background(), msgs, func(context.Context, Batch) error {
+		return nil
+	})
+
+	assert.EqualError(t, err, "gomsgprocessor.streamProcessor.ProcessSeq: build error")
+	assert.Equal(t, ErrCodeBuildDocuments, errors.GetCode(err))
+}
+
+func Test_StreamProcessor_FlushInterval(t *testing.T) {
+	t.Parallel()
+
+	batches := make(chan Batch, 1)
+
+	// The channel is only closed after the first batch is received, so it
+	// must be flushed by the interval.
+	msgs := make(chan Message)
+	go func() {
+		defer close(msgs)
+		msgs <- &mockMessage{id: "1", namespace: "tiramisu", messageType: "type-1"}
+		select {
+		case <-batches:
+		case <-time.After(5 * time.Second):
+			t.Error("batch was not flushed by the interval")
+		}
+	}()
+
+	streamProcessor := NewStreamProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": newConcurrencyTrackingBuilder(),
+		},
+		WithStreamBatchSizeOption(100),
+		WithStreamFlushIntervalOption(10*time.Millisecond),
+	)
+
+	err := streamProcessor.ProcessChannel(context.Background(), msgs, func(_ context.Context, batch Batch) error {
+		batches <- batch
+		return nil
+	})
+
+	assert.NoError(t, err)
+}
+
+func Test_StreamProcessor_MessageTypeMaxWorkers(t *testing.T) {
+	t.Parallel()
+
+	// The messages of type-1 are only built after the message of type-2, read
+	// after them, so it must not wait for the type-1 limit.
+	type2Built := make(chan struct{})
+	streamProcessor := NewStreamProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": DocumentBuilderFunc(func(_ context.Context, msg Message) ([]Document, error) {
+				select {
+				case <-type2Built:
+				case <-time.After(5 * time.Second):
+					return nil, errors.New("type-2 message was not built")
+				}
+				return []Document{mockDocument{id: msg.(*mockMessage).id}}, nil
+			}),
+			"type-2": DocumentBuilderFunc(func(_ context.Context, msg Message) ([]Document, error) {
+				close(type2Built)
+				return []Document{mockDocument{id: msg.(*mockMessage).id}}, nil
+			}),
+		},
+		WithMaxWorkersOption(4),
+		WithMessageTypeMaxWorkersOption("type-1", 1),
+	)
+
+	msgs := append(
+		makeMockMessages(3, "tiramisu", "type-1"),
+		makeMockMessages(1, "tiramisu", "type-2")...,
+	)
+	documents := 0
+	err := streamProcessor.ProcessSeq(context.Background(), slices.Values(msgs), func(_ context.Context, batch Batch) error {
+		documents += len(batch.Documents)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, documents)
+}
+
+func Test_StreamProcessor_ProcessSeq(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+
+		builders map[MessageType]DocumentBuilder
+		handle   BatchHandler
+
+		expectedDocuments int
+		expectedError     string
+		expectedErrorCode errors.Code
+	}{
+		{
+			name: "success",
+			builders: map[MessageType]DocumentBuilder{
+				"type-1": newConcurrencyTrackingBuilder(),
+			},
+			handle: func(context.Context, Batch) error {
+				return nil
+			},
+			expectedDocuments: 4,
+		},
+		{
+			name:     "errors - no document builder for message",
+			builders: map[MessageType]DocumentBuilder{},
+			handle: func(context.Context, Batch) error {
+				return nil
+			},
+			expectedError:     "gomsgprocessor.streamProcessor.ProcessSeq: message type has no document builder [type=type-1]",
+			expectedErrorCode: ErrCodeBuildDocuments,
+		},
+		{
+			name: "errors - handle batch",
+			builders: map[MessageType]DocumentBuilder{
+				"type-1": newConcurrencyTrackingBuilder(),
+			},
+			handle: func(context.Context, Batch) error {
+				return errors.New("handle batch error")
+			},
+			expectedError:     "gomsgprocessor.streamProcessor.ProcessSeq: handle batch error",
+			expectedErrorCode: ErrCodeHandleBatch,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			streamProcessor := NewStreamProcessor(test.builders)
+
+			documents := 0
+			err := streamProcessor.ProcessSeq(
+				context.Background(),
+				slices.Values(makeMockMessages(4, "tiramisu", "type-1")),
+				func(ctx context.Context, batch Batch) error {
+					documents += len(batch.Documents)
+					return test.handle(ctx, batch)
+				},
+			)
+
+			if test.expectedError == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedDocuments, documents)
+			} else {
+				assert.EqualError(t, err, test.expectedError)
+				assert.Equal(t, test.expectedErrorCode, errors.GetCode(err))
+			}
+		})
+	}
+}
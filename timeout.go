@@ -0,0 +1,40 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// buildTimeoutFor returns the Build timeout registered for the MessageType,
+// falling back to the processor's default one. Zero means no timeout.
+func (p *parallelProcessor) buildTimeoutFor(messageType MessageType) time.Duration {
+	if timeout, ok := p.buildTimeoutByType[messageType]; ok {
+		return timeout
+	}
+	return p.buildTimeout
+}
+
+// withBuildTimeout calls build with a context that expires after timeout. If
+// build fails because of it, the error has ErrCodeBuildTimeout associated
+// with. A timeout lower than 1 calls build with the given context.
+func withBuildTimeout[T any](
+	ctx context.Context,
+	timeout time.Duration,
+	build func(context.Context) (T, error),
+) (T, error) {
+	if timeout <= 0 {
+		return build(ctx)
+	}
+
+	buildCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	output, err := build(buildCtx)
+	if err != nil && ctx.Err() == nil && buildCtx.Err() != nil {
+		var zero T
+		return zero, errors.E(err, ErrCodeBuildTimeout, errors.KV("timeout", timeout))
+	}
+	return output, err
+}
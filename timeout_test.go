@@ -0,0 +1,71 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Process_BuildTimeout(t *testing.T) {
+	t.Parallel()
+
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": slowDocumentBuilder{},
+			"type-2": slowDocumentBuilder{},
+		},
+		WithBuildTimeoutOption(time.Millisecond),
+		WithMessageTypeBuildTimeoutOption("type-2", time.Minute),
+	)
+
+	result, err := parallelProcessor.Process(
+		context.Background(),
+		append(
+			makeMockMessages(1, "tiramisu", "type-1"),
+			makeMockMessages(1, "tiramisu", "type-2")...,
+		),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Document{mockDocument{id: "tiramisu-type-2-0"}}, result.Documents)
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, 0, result.Failures[0].Index)
+		assert.Equal(t, ErrCodeBuildTimeout, result.Failures[0].Code)
+		assert.EqualError(t, result.Failures[0].Err, "context deadline exceeded [timeout=1ms]")
+	}
+}
+
+func Test_MakeDocuments_BuildTimeout(t *testing.T) {
+	t.Parallel()
+
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": slowDocumentBuilder{},
+		},
+		WithBuildTimeoutOption(time.Millisecond),
+	)
+
+	_, err := parallelProcessor.MakeDocuments(
+		context.Background(),
+		makeMockMessages(1, "tiramisu", "type-1"),
+	)
+
+	assert.EqualError(t, err, "gomsgprocessor.parallelProcessor.MakeDocuments: parallelBuildDocumentsByNamespace: context deadline exceeded [timeout=1ms]")
+	assert.Equal(t, ErrCodeBuildDocuments, errors.GetCode(err))
+}
+
+// slowDocumentBuilder takes 10ms to build a message, unless its context is
+// done first.
+type slowDocumentBuilder struct{}
+
+func (slowDocumentBuilder) Build(ctx context.Context, msg Message) ([]Document, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(10 * time.Millisecond):
+		return []Document{mockDocument{id: msg.(*mockMessage).id}}, nil
+	}
+}
@@ -0,0 +1,117 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"math/rand/v2"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the child spans.
+const tracerName = "github.com/arquivei/gomsgprocessor"
+
+// spanConfig holds which child spans the processor creates.
+type spanConfig struct {
+	buildSampleRate float64
+	deduplicate     bool
+	tracerProvider  oteltrace.TracerProvider
+}
+
+// sampleBuild decides if the builds of a Message get their own spans.
+func (c spanConfig) sampleBuild() bool {
+	if c.buildSampleRate <= 0 {
+		return false
+	}
+	//nolint:gosec // sampling does not need a secure random number.
+	return c.buildSampleRate >= 1 || rand.Float64() < c.buildSampleRate
+}
+
+// tracer returns the Tracer of the configured TracerProvider, or of the global
+// one if none was set.
+func (c spanConfig) tracer() oteltrace.Tracer {
+	if c.tracerProvider == nil {
+		return otel.Tracer(tracerName)
+	}
+	return c.tracerProvider.Tracer(tracerName)
+}
+
+// endSpan records err, if any, in the span and ends it.
+func endSpan(span oteltrace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceBuild returns a buildFunc that runs the given one inside a child span
+// tagged with the Message's type and namespace.
+func traceBuild(tracer oteltrace.Tracer, msg Message, build buildFunc) buildFunc {
+	return func(ctx context.Context) (output buildOutput, err error) {
+		ctx, span := tracer.Start(
+			ctx,
+			"gomsgprocessor.DocumentBuilder.Build",
+			oteltrace.WithAttributes(
+				attribute.String("gomsgprocessor.message_type", string(msg.GetType())),
+				attribute.String("gomsgprocessor.namespace", string(msg.GetNamespace())),
+			),
+		)
+		defer func() { endSpan(span, err) }()
+
+		return build(ctx)
+	}
+}
+
+// traceBuildBatch returns a function that runs the given BuildBatch call
+// inside a child span tagged with the messages' type, namespace and count.
+func traceBuildBatch(
+	tracer oteltrace.Tracer,
+	msgs []Message,
+	build func(context.Context) ([]BatchBuildResult, error),
+) func(context.Context) ([]BatchBuildResult, error) {
+	return func(ctx context.Context) (results []BatchBuildResult, err error) {
+		ctx, span := tracer.Start(
+			ctx,
+			"gomsgprocessor.BatchDocumentBuilder.BuildBatch",
+			oteltrace.WithAttributes(
+				attribute.String("gomsgprocessor.message_type", string(msgs[0].GetType())),
+				attribute.String("gomsgprocessor.namespace", string(msgs[0].GetNamespace())),
+				attribute.Int("gomsgprocessor.messages", len(msgs)),
+			),
+		)
+		defer func() { endSpan(span, err) }()
+
+		return build(ctx)
+	}
+}
+
+// traceDeduplicate runs deduplicate inside a child span tagged with the
+// Namespace and the number of documents.
+func traceDeduplicate(
+	ctx context.Context,
+	tracer oteltrace.Tracer,
+	namespace Namespace,
+	documents []Document,
+	deduplicate DeduplicateDocumentsFunc,
+) (deduplicatedDocuments []Document, err error) {
+	_, span := tracer.Start(
+		ctx,
+		"gomsgprocessor.DeduplicateDocumentsFunc",
+		oteltrace.WithAttributes(
+			attribute.String("gomsgprocessor.namespace", string(namespace)),
+			attribute.Int("gomsgprocessor.documents", len(documents)),
+		),
+	)
+	defer func() { endSpan(span, err) }()
+
+	deduplicatedDocuments, err = deduplicate(documents)
+
+	span.SetAttributes(
+		attribute.Int("gomsgprocessor.deduplicated_documents", len(deduplicatedDocuments)),
+	)
+
+	return deduplicatedDocuments, err
+}
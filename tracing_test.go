@@ -0,0 +1,119 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func Test_spanConfig_sampleBuild(t *testing.T) {
+	t.Parallel()
+
+	assert.False(t, spanConfig{}.sampleBuild())
+	assert.False(t, spanConfig{buildSampleRate: -1}.sampleBuild())
+	assert.True(t, spanConfig{buildSampleRate: 1}.sampleBuild())
+	assert.True(t, spanConfig{buildSampleRate: 2}.sampleBuild())
+}
+
+func Test_MakeDocuments_Spans(t *testing.T) {
+	t.Parallel()
+
+	recorder := tracetest.NewSpanRecorder()
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": newConcurrencyTrackingBuilder(),
+		},
+		WithBuildSpansOption(1),
+		WithDeduplicateSpansOption(),
+		WithTracerProviderOption(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+	)
+
+	documents, err := parallelProcessor.MakeDocuments(
+		context.Background(),
+		makeMockMessages(2, "tiramisu", "type-1"),
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		[]Document{
+			mockDocument{id: "tiramisu-type-1-0"},
+			mockDocument{id: "tiramisu-type-1-1"},
+		},
+		documents,
+	)
+
+	attributesBySpan := make(map[string][][]attribute.KeyValue)
+	for _, span := range recorder.Ended() {
+		attributesBySpan[span.Name()] = append(attributesBySpan[span.Name()], span.Attributes())
+	}
+	assert.Equal(
+		t,
+		map[string][][]attribute.KeyValue{
+			"gomsgprocessor.DocumentBuilder.Build": {
+				{
+					attribute.String("gomsgprocessor.message_type", "type-1"),
+					attribute.String("gomsgprocessor.namespace", "tiramisu"),
+				},
+				{
+					attribute.String("gomsgprocessor.message_type", "type-1"),
+					attribute.String("gomsgprocessor.namespace", "tiramisu"),
+				},
+			},
+			"gomsgprocessor.DeduplicateDocumentsFunc": {
+				{
+					attribute.String("gomsgprocessor.namespace", "tiramisu"),
+					attribute.Int("gomsgprocessor.documents", 2),
+					attribute.Int("gomsgprocessor.deduplicated_documents", 2),
+				},
+			},
+		},
+		attributesBySpan,
+	)
+}
+
+func Test_MakeDocuments_BatchSpans(t *testing.T) {
+	t.Parallel()
+
+	recorder := tracetest.NewSpanRecorder()
+	parallelProcessor := NewParallelProcessor(
+		map[MessageType]DocumentBuilder{
+			"type-1": &batchingDocumentBuilder{},
+		},
+		WithBuildBatchSizeOption(2),
+		WithBuildSpansOption(1),
+		WithTracerProviderOption(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+	)
+
+	_, err := parallelProcessor.MakeDocuments(
+		context.Background(),
+		makeMockMessages(3, "tiramisu", "type-1"),
+	)
+	assert.NoError(t, err)
+
+	var attributes [][]attribute.KeyValue
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, "gomsgprocessor.BatchDocumentBuilder.BuildBatch", span.Name())
+		attributes = append(attributes, span.Attributes())
+	}
+	assert.ElementsMatch(
+		t,
+		[][]attribute.KeyValue{
+			{
+				attribute.String("gomsgprocessor.message_type", "type-1"),
+				attribute.String("gomsgprocessor.namespace", "tiramisu"),
+				attribute.Int("gomsgprocessor.messages", 2),
+			},
+			{
+				attribute.String("gomsgprocessor.message_type", "type-1"),
+				attribute.String("gomsgprocessor.namespace", "tiramisu"),
+				attribute.Int("gomsgprocessor.messages", 1),
+			},
+		},
+		attributes,
+	)
+}
@@ -0,0 +1,198 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// TypedDocumentBuilder is a type-safe DocumentBuilder that transforms a message
+// of type M into documents of type D.
+type TypedDocumentBuilder[M Message, D any] interface {
+	// Build transforms a M into []D.
+	Build(context.Context, M) ([]D, error)
+}
+
+// TypedDeduplicateDocumentsFunc is a type-safe DeduplicateDocumentsFunc, used
+// to deduplicate a slice of D.
+type TypedDeduplicateDocumentsFunc[D any] func([]D) ([]D, error)
+
+// TypedParallelProcessor is a type-safe ParallelProcessor that process in
+// parallel a slice of M into a slice of D.
+type TypedParallelProcessor[M Message, D any] interface {
+	// MakeDocuments creates in parallel a slice of D for given []M using the
+	// map of TypedDocumentBuilder (see NewTypedParallelProcessor).
+	//
+	// Errors are the same returned by ParallelProcessor's MakeDocuments.
+	MakeDocuments(context.Context, []M) ([]D, error)
+
+	// MakeDocumentsByNamespace is like MakeDocuments, but returns the
+	// documents grouped by Namespace. See ParallelProcessor's
+	// MakeDocumentsByNamespace.
+	MakeDocumentsByNamespace(context.Context, []M) (map[Namespace][]D, error)
+
+	// CommitDocumentsByNamespace adds the keys of the documents returned by
+	// MakeDocumentsByNamespace to their DedupStore. See ParallelProcessor's
+	// CommitDocumentsByNamespace.
+	CommitDocumentsByNamespace(context.Context, map[Namespace][]D) error
+}
+
+type typedParallelProcessor[M Message, D any] struct {
+	processor ParallelProcessor
+}
+
+// NewTypedParallelProcessor returns a new TypedParallelProcessor with a map of
+// TypedDocumentBuilder for each MessageType.
+//
+// It is built on top of a ParallelProcessor, so all Option are also available
+// for this method. Use WithTypedDeduplicateDocumentsOption to set a
+// TypedDeduplicateDocumentsFunc.
+func NewTypedParallelProcessor[M Message, D any](
+	builders map[MessageType]TypedDocumentBuilder[M, D],
+	opts ...Option,
+) TypedParallelProcessor[M, D] {
+	adaptedBuilders := make(map[MessageType]DocumentBuilder, len(builders))
+	for messageType, builder := range builders {
+		adaptedBuilders[messageType] = AdaptDocumentBuilder(builder)
+	}
+
+	return &typedParallelProcessor[M, D]{
+		processor: NewParallelProcessor(adaptedBuilders, opts...),
+	}
+}
+
+func (p *typedParallelProcessor[M, D]) MakeDocuments(ctx context.Context, msgs []M) ([]D, error) {
+	const op = errors.Op("gomsgprocessor.typedParallelProcessor.MakeDocuments")
+
+	documents, err := p.processor.MakeDocuments(ctx, toMessages(msgs))
+	if err != nil {
+		return nil, err
+	}
+
+	typedDocuments, err := castDocuments[D](documents)
+	if err != nil {
+		return nil, errors.E(op, err, ErrCodeBuildDocuments)
+	}
+	return typedDocuments, nil
+}
+
+func (p *typedParallelProcessor[M, D]) MakeDocumentsByNamespace(
+	ctx context.Context,
+	msgs []M,
+) (map[Namespace][]D, error) {
+	const op = errors.Op("gomsgprocessor.typedParallelProcessor.MakeDocumentsByNamespace")
+
+	documentsByNamespace, err := p.processor.MakeDocumentsByNamespace(ctx, toMessages(msgs))
+	if err != nil {
+		return nil, err
+	}
+
+	typedDocumentsByNamespace := make(map[Namespace][]D, len(documentsByNamespace))
+	for namespace, documents := range documentsByNamespace {
+		typedDocuments, err := castDocuments[D](documents)
+		if err != nil {
+			return nil, errors.E(op, err, ErrCodeBuildDocuments)
+		}
+		typedDocumentsByNamespace[namespace] = typedDocuments
+	}
+	return typedDocumentsByNamespace, nil
+}
+
+func (p *typedParallelProcessor[M, D]) CommitDocumentsByNamespace(
+	ctx context.Context,
+	typedDocumentsByNamespace map[Namespace][]D,
+) error {
+	documentsByNamespace := make(map[Namespace][]Document, len(typedDocumentsByNamespace))
+	for namespace, typedDocuments := range typedDocumentsByNamespace {
+		documentsByNamespace[namespace] = toDocuments(typedDocuments)
+	}
+	return p.processor.CommitDocumentsByNamespace(ctx, documentsByNamespace)
+}
+
+// AdaptDocumentBuilder returns a DocumentBuilder that calls the given
+// TypedDocumentBuilder. Building a Message that is not a M returns
+// ErrUnexpectedMessageType.
+func AdaptDocumentBuilder[M Message, D any](builder TypedDocumentBuilder[M, D]) DocumentBuilder {
+	return typedDocumentBuilderAdapter[M, D]{builder: builder}
+}
+
+type typedDocumentBuilderAdapter[M Message, D any] struct {
+	builder TypedDocumentBuilder[M, D]
+}
+
+func (a typedDocumentBuilderAdapter[M, D]) Build(ctx context.Context, msg Message) ([]Document, error) {
+	typedMsg, ok := msg.(M)
+	if !ok {
+		return nil, errors.E(ErrUnexpectedMessageType, errors.KV("type", fmt.Sprintf("%T", msg)))
+	}
+
+	typedDocuments, err := a.builder.Build(ctx, typedMsg)
+	if err != nil || typedDocuments == nil {
+		return nil, err
+	}
+
+	return toDocuments(typedDocuments), nil
+}
+
+// AdaptDeduplicateDocumentsFunc returns a DeduplicateDocumentsFunc that calls
+// the given TypedDeduplicateDocumentsFunc. Deduplicating a Document that is not
+// a D returns ErrUnexpectedDocumentType.
+func AdaptDeduplicateDocumentsFunc[D any](f TypedDeduplicateDocumentsFunc[D]) DeduplicateDocumentsFunc {
+	return func(documents []Document) ([]Document, error) {
+		typedDocuments, err := castDocuments[D](documents)
+		if err != nil {
+			return nil, err
+		}
+
+		deduplicatedDocuments, err := f(typedDocuments)
+		if err != nil {
+			return nil, err
+		}
+		return toDocuments(deduplicatedDocuments), nil
+	}
+}
+
+// WithTypedDeduplicateDocumentsOption adds a TypedDeduplicateDocumentsFunc in
+// the processor, used to deduplicate a slice of D.
+func WithTypedDeduplicateDocumentsOption[D any](f TypedDeduplicateDocumentsFunc[D]) Option {
+	return WithDeduplicateDocumentsOption(AdaptDeduplicateDocumentsFunc(f))
+}
+
+// WithTypedNamespaceDeduplicateDocumentsOption adds a
+// TypedDeduplicateDocumentsFunc used only for the documents of the given
+// Namespace. See WithNamespaceDeduplicateDocumentsOption.
+func WithTypedNamespaceDeduplicateDocumentsOption[D any](
+	ns Namespace,
+	f TypedDeduplicateDocumentsFunc[D],
+) Option {
+	return WithNamespaceDeduplicateDocumentsOption(ns, AdaptDeduplicateDocumentsFunc(f))
+}
+
+func toMessages[M Message](msgs []M) []Message {
+	messages := make([]Message, len(msgs))
+	for i, msg := range msgs {
+		messages[i] = msg
+	}
+	return messages
+}
+
+func toDocuments[D any](typedDocuments []D) []Document {
+	documents := make([]Document, len(typedDocuments))
+	for i, typedDocument := range typedDocuments {
+		documents[i] = typedDocument
+	}
+	return documents
+}
+
+func castDocuments[D any](documents []Document) ([]D, error) {
+	typedDocuments := make([]D, len(documents))
+	for i, document := range documents {
+		typedDocument, ok := document.(D)
+		if !ok {
+			return nil, errors.E(ErrUnexpectedDocumentType, errors.KV("type", fmt.Sprintf("%T", document)))
+		}
+		typedDocuments[i] = typedDocument
+	}
+	return typedDocuments, nil
+}
@@ -0,0 +1,73 @@
+package gomsgprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TypedParallelProcessor_MakeDocuments(t *testing.T) {
+	t.Parallel()
+
+	parallelProcessor := NewTypedParallelProcessor(
+		map[MessageType]TypedDocumentBuilder[*mockMessage, mockDocument]{
+			"type-1": mockTypedDocumentBuilder{},
+		},
+		WithTypedDeduplicateDocumentsOption(func(docs []mockDocument) ([]mockDocument, error) {
+			return docs[:1], nil
+		}),
+	)
+
+	documents, err := parallelProcessor.MakeDocuments(
+		context.Background(),
+		[]*mockMessage{
+			{id: "id-1", namespace: "tiramisu", messageType: "type-1"},
+			{id: "id-2", namespace: "tiramisu", messageType: "type-1"},
+		},
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []mockDocument{{id: "id-1"}}, documents)
+}
+
+func Test_AdaptDocumentBuilder(t *testing.T) {
+	t.Parallel()
+
+	builder := AdaptDocumentBuilder[*mockMessage, mockDocument](mockTypedDocumentBuilder{})
+
+	documents, err := builder.Build(
+		context.Background(),
+		&mockMessage{id: "id-1", namespace: "tiramisu", messageType: "type-1"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []Document{mockDocument{id: "id-1"}}, documents)
+
+	_, err = builder.Build(context.Background(), &otherMockMessage{})
+	assert.EqualError(t, err, "unexpected message type [type=*gomsgprocessor.otherMockMessage]")
+}
+
+func Test_AdaptDeduplicateDocumentsFunc(t *testing.T) {
+	t.Parallel()
+
+	deduplicate := AdaptDeduplicateDocumentsFunc(func(docs []mockDocument) ([]mockDocument, error) {
+		return docs, nil
+	})
+
+	documents, err := deduplicate([]Document{mockDocument{id: "doc-1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Document{mockDocument{id: "doc-1"}}, documents)
+
+	_, err = deduplicate([]Document{"doc-1"})
+	assert.EqualError(t, err, "unexpected document type [type=string]")
+}
+
+type mockTypedDocumentBuilder struct{}
+
+func (mockTypedDocumentBuilder) Build(_ context.Context, msg *mockMessage) ([]mockDocument, error) {
+	return []mockDocument{{id: msg.id}}, nil
+}
+
+type otherMockMessage struct {
+	mockMessage
+}